@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"log"
 
@@ -12,7 +11,7 @@ import (
 )
 
 var (
-	gzipDir   = pflag.String("dir", "data/", "Directory with GZIP files containing New York Times articles (filenames must end in `.json.gz`)")
+	gzipDir   = pflag.String("dir", "data/", "Directory (searched recursively), file or glob with GZIP files containing New York Times articles (filenames must end in `.json.gz` and contain a date, e.g. `articles-YYYY-M.json.gz`)")
 	startFrom = pflag.String("start-from", "", "File to (re)start from")
 	maxDocs   = pflag.Int("max-docs", 0, "Max number of docs to index")
 	num       = pflag.Int("num", 10, "Number of sentences to generate")
@@ -31,7 +30,7 @@ func main() {
 	d := datagen.NewDictionary()
 	wg := datagen.NewWordGraph()
 
-	loader.ReadDirWithArticles(loader.ReadDirWithArticlesParams{
+	sum, err := loader.ReadDirWithArticles(loader.ReadDirWithArticlesParams{
 		Path:      *gzipDir,
 		Suffix:    ".json.gz",
 		Verbose:   *verbose,
@@ -45,13 +44,19 @@ func main() {
 				wg.AddText(a.Abstract + " " + a.Headline.Main + " " + a.LeadParagraph)
 			}
 
-			if articlesTotal >= *maxDocs {
-				return errors.New("nope")
-			}
-
 			return nil
 		},
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(sum.UnknownFiles) > 0 && !*verbose {
+		fmt.Printf("Skipped %d files without a date in their names, see --verbose\n", len(sum.UnknownFiles))
+	}
+	if sum.Articles == 0 {
+		log.Fatalf("no articles found in %s", *gzipDir)
+	}
 
 	// d.Stats()
 	// wg.Dump()
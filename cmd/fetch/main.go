@@ -1,143 +1,535 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/anrid/nytimes/pkg/fetch"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
-const (
-	NYTimesArchiveAPIURL = "https://api.nytimes.com/svc/archive/v1/%d/%d.json?api-key="
+var (
+	year    = pflag.Int("year", 0, "Start fetching NY Times articles from this year (YYYY, integer, required unless --months is used)")
+	month   = pflag.Int("month", 0, "Start fetching NY Times articles from this month (M, integer, required unless --months is used)")
+	toYear  = pflag.Int("to-year", 0, "Stop fetching NY Times articles after this year (YYYY, integer, defaults to the current year)")
+	toMonth = pflag.Int("to-month", 0, "Stop fetching NY Times articles after this month (M, integer, defaults to the current month or 12 if --to-year is set)")
+	months  = pflag.String("months", "", "Comma separated list of months to fetch, e.g. 1999-01,2001-07 (overrides --year, --month, --to-year and --to-month)")
+	reverse = pflag.Bool("reverse", false, "Fetch months in reverse order, newest first")
+	outDir  = pflag.String("dir", "data/", "Directory to write fetched articles to")
+	baseURL = pflag.String("base-url", fetch.DefaultAPIBaseURL, "NY Times API base URL")
+	format  = pflag.String("format", "raw", "Format to store articles in, available: ['raw' (API response as is), 'compact', 'pretty' (indented), 'ndjson' (one article per line)]")
+	source  = pflag.String("source", "archive", "API to fetch articles from, available: ['archive', 'search', 'topstories', 'mostpopular']")
+
+	query       = pflag.String("query", "", "Article Search API query, fetched month by month (--source search)")
+	filterQuery = pflag.String("filter-query", "", "Article Search API filter query, e.g. section_name:\"Sports\" (--source search)")
+	sections    = pflag.String("sections", "home", "Comma separated list of Top Stories API sections (--source topstories)")
+	popular     = pflag.String("popular", "viewed", "Comma separated list of Most Popular API lists, available: ['viewed', 'shared', 'emailed'] (--source mostpopular)")
+	period      = pflag.Int("period", 1, "Most Popular API period in days, available: [1, 7, 30] (--source mostpopular)")
+
+	perMinute  = pflag.Int("per-minute", fetch.DefaultRequestsPerMinute, "Max number of API requests per minute (0 = unlimited)")
+	perDay     = pflag.Int("per-day", fetch.DefaultRequestsPerDay, "Max number of API requests per day (0 = unlimited)")
+	quotaFile  = pflag.String("quota-file", "data/.quota.json", "File to persist the daily API request count in (empty = don't persist)")
+	maxRetries = pflag.Int("max-retries", 5, "Max number of retries on rate limit, 5xx and network errors")
+	keyFile    = pflag.String("key-file", "", "File with one API key per line, used instead of the SHINY_NYTIMES_API_KEY env var (which may also contain a comma separated list of keys)")
+
+	verify  = pflag.Bool("verify", false, "Verify all files in --dir against the manifest and re-fetch mismatches")
+	verbose = pflag.BoolP("verbose", "v", false, "Verbose output")
+
+	refreshCurrent   = pflag.Bool("refresh-current", false, "Re-fetch the current month even if it has already been fetched")
+	refreshOlderThan = pflag.Duration("refresh-older-than", 0, "Re-fetch months that were still open when fetched, if fetched longer ago than this, e.g. 24h")
 )
 
-func main() {
-	year := pflag.Int("year", 0, "Start fetching NY Times articles from this year (YYYY, integer, required)")
-	month := pflag.Int("month", 0, "Start fetching NY Times articles from this month (M, integer, required)")
+type fetcher struct {
+	c            *fetch.ArchiveClient // Template for the per key archive clients.
+	manifest     *fetch.Manifest
+	manifestFile string
+	now          time.Time
+}
 
+func main() {
 	pflag.Parse()
 
-	key := os.Getenv("SHINY_NYTIMES_API_KEY")
-	if key == "" {
-		log.Panicf("env var SHINY_NYTIMES_API_KEY not set")
+	keys, err := apiKeys()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	removed, err := util.RemoveTempFiles(*outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range removed {
+		fmt.Printf("Removed temp file left behind by an interrupted write: %s\n", f)
+	}
+
+	manifestFile := filepath.Join(*outDir, fetch.ManifestFile)
+
+	manifest, err := fetch.LoadManifest(manifestFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if *year == 0 || *month == 0 {
+	c := fetch.NewArchiveClient(nil, *outDir)
+	c.BaseURL = strings.TrimSuffix(*baseURL, "/") + "/archive/v1"
+	c.Format, err = fetch.ParseFormat(*format)
+	if err != nil {
 		pflag.Usage()
-		os.Exit(-1)
+		log.Fatal(err)
 	}
 
-	thisYear, _ := strconv.Atoi(time.Now().Format("2006"))
-	thisMonth, _ := strconv.Atoi(time.Now().Format("1"))
+	// Every API key gets its own client and rate limiter.
+	var clients []*fetch.Client
+	var limiters []*fetch.RateLimiter
 
-	for {
-		outfile := fmt.Sprintf("data/articles-%d-%d.json.gz", *year, *month)
-		var fileExists bool
-		var fileExistsAndIsValid bool
-		var performedAPICall bool
-		retries := 3
+	for _, key := range keys {
+		id := fetch.KeyID(key)
+
+		qf := *quotaFile
+		if qf != "" && len(keys) > 1 {
+			qf = strings.TrimSuffix(qf, ".json") + "-" + id + ".json"
+		}
 
-		stat, err := os.Stat(outfile)
+		limiter, err := fetch.NewRateLimiter(*perMinute, *perDay, qf)
 		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Panic(err)
+			log.Fatal(err)
+		}
+
+		kc := fetch.NewClient(fetch.StaticKey(key))
+		if len(keys) > 1 {
+			kc.Name = "key " + id
+		}
+		kc.Limiter = limiter
+		kc.Backoff = fetch.DefaultBackoff()
+		kc.Backoff.MaxRetries = *maxRetries
+		kc.OnRetry = func(attempt int, delay time.Duration, err error) {
+			printf(kc, "Request failed: %s\nRetrying in %s (attempt %d of %d) ..\n", err, delay.Round(time.Millisecond), attempt, *maxRetries)
+		}
+
+		clients = append(clients, kc)
+		limiters = append(limiters, limiter)
+	}
+
+	usedToday := func() (used int) {
+		for _, l := range limiters {
+			used += l.Used()
+		}
+		return
+	}
+
+	sched := fetch.NewScheduler(clients)
+	sched.OnRetire = func(kc *fetch.Client, err error) {
+		printf(kc, "Retiring API key: %s\n", err)
+	}
+
+	var jobs []fetch.Job
+	var todo []fetch.Month
+
+	if *source != "archive" {
+		sources, err := sourcesToFetch(manifest)
+		if err != nil {
+			pflag.Usage()
+			log.Fatal(err)
+		}
+
+		for _, s := range sources {
+			s := s
+			jobs = append(jobs, func(ctx context.Context, kc *fetch.Client) error {
+				return errors.Wrapf(fetchSource(ctx, kc, s, c.Format), "could not fetch %s", s.FileName())
+			})
+		}
+	} else {
+		f := &fetcher{c: c, manifest: manifest, manifestFile: manifestFile, now: time.Now()}
+
+		addJob := func(m fetch.Month, format fetch.Format) {
+			todo = append(todo, m)
+			jobs = append(jobs, func(ctx context.Context, kc *fetch.Client) error {
+				return errors.Wrapf(f.fetchMonth(ctx, kc, m, format, *verify), "could not fetch %s", m)
+			})
+		}
+
+		if *verify {
+			invalid := verifyManifest(manifest)
+			if len(invalid) == 0 {
+				fmt.Printf("Verified %d files, all good! Donezo!\n", len(manifest.Files))
+				return
+			}
+			fmt.Printf("Found %d invalid file(s), re-fetching ..\n", len(invalid))
+
+			// Re-fetch files in the format they were stored in so that they
+			// replace the invalid file and its manifest entry.
+			for _, e := range invalid {
+				addJob(fetch.Month{Year: e.Year, Month: e.Month}, fetch.FileFormat(e.File, c.Format))
 			}
 		} else {
-			fileExists = true
+			months, err := monthsToFetch(manifest)
+			if err != nil {
+				pflag.Usage()
+				log.Fatal(err)
+			}
+			for _, m := range months {
+				addJob(m, c.Format)
+			}
 		}
+	}
 
-		fileExistsAndIsValid = fileExists && stat.Size() > 1_000
+	if len(keys) > 1 {
+		fmt.Printf("Fetching concurrently with %d API keys ..\n", len(keys))
+	}
 
-		if !fileExistsAndIsValid {
-			url := fmt.Sprintf(NYTimesArchiveAPIURL, *year, *month)
+	remaining, err := sched.Run(context.Background(), jobs)
+	if err != nil {
+		if errors.Is(err, fetch.ErrDailyQuotaExceeded) {
+			fmt.Printf("Stopping with %d of %d file(s) left to fetch: %s\nRun again tomorrow to continue!\n", remaining, len(jobs), err)
+			fmt.Printf("Used %d API requests today\n", usedToday())
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
 
-			var prettyJ []byte
+	if *source != "archive" {
+		fmt.Printf("Fetched %d file(s) from the %s API! Donezo!\n", len(jobs), *source)
+	} else {
+		// With --reverse the newest month comes first.
+		last := todo[0]
+		for _, m := range todo[1:] {
+			if last.Before(m) {
+				last = m
+			}
+		}
+		if last == fetch.CurrentMonth(time.Now()) {
+			fmt.Printf("We're at %s - we're all caught up in time! Donezo!\n", last)
+		} else {
+			fmt.Printf("We're at %s - fetched %d month(s) in total! Donezo!\n", last, len(todo))
+		}
+	}
+	fmt.Printf("Used %d API requests today\n", usedToday())
+}
 
-			for ; retries > 0; retries-- {
-				fmt.Printf("Fetching URL: %s\n", url)
+// apiKeys returns the API keys to use, read from --key-file or the
+// SHINY_NYTIMES_API_KEY env var (comma separated).
+func apiKeys() ([]string, error) {
+	var keys []string
 
-				resp, err := http.Get(url + key)
-				if err != nil {
-					log.Panic(err)
-				}
+	if *keyFile != "" {
+		var err error
+		keys, err = fetch.ReadKeyFile(*keyFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keys = fetch.ParseKeys(os.Getenv(fetch.DefaultAPIKeyEnvVar))
+	}
 
-				performedAPICall = true
+	if len(keys) == 0 {
+		return nil, errors.Wrapf(fetch.ErrMissingKey, "env var %s not set and no --key-file given", fetch.DefaultAPIKeyEnvVar)
+	}
 
-				data, err := io.ReadAll(resp.Body)
-				if err != nil {
-					log.Panic(err)
-				}
+	return keys, nil
+}
 
-				j := make(map[string]interface{})
-				err = json.Unmarshal(data, &j)
-				if err != nil {
-					log.Panic(err)
-				}
+// printf prints a message prefixed with the client's name, if any.
+func printf(kc *fetch.Client, format string, args ...interface{}) {
+	if kc.Name != "" {
+		format = "[" + kc.Name + "] " + format
+	}
+	fmt.Printf(format, args...)
+}
 
-				prettyJ, err = json.MarshalIndent(j, "", "  ")
-				if err != nil {
-					log.Panic(err)
-				}
+// sourcesToFetch returns the non-archive sources to fetch based on the
+// given flags.
+func sourcesToFetch(manifest *fetch.Manifest) ([]fetch.Source, error) {
+	var sources []fetch.Source
+	now := time.Now()
 
-				if len(prettyJ) < 1_000 {
-					if strings.Contains(string(prettyJ), "\"docs\": [],") {
-						fmt.Println("No docs returned, skipping this year and month!")
-						break
-					}
+	switch *source {
+	case "search":
+		todo, err := monthsToFetch(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range todo {
+			s := fetch.NewArticleSearch(*baseURL, *query, m)
+			s.FilterQuery = *filterQuery
+			sources = append(sources, s)
+		}
+	case "topstories":
+		for _, section := range strings.Split(*sections, ",") {
+			if section = strings.TrimSpace(section); section != "" {
+				sources = append(sources, fetch.NewTopStories(*baseURL, section, now))
+			}
+		}
+	case "mostpopular":
+		for _, kind := range strings.Split(*popular, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				sources = append(sources, fetch.NewMostPopular(*baseURL, kind, *period, now))
+			}
+		}
+	default:
+		return nil, errors.Errorf("incorrect --source arg `%s`", *source)
+	}
 
-					if strings.Contains(string(prettyJ), "policies.ratelimit.QuotaViolation") {
-						fmt.Printf("Rate limited! Sleeping for 6 sec before trying again (%d retries left) ..\n", retries)
-						time.Sleep(6 * time.Second)
-						continue
-					}
+	if len(sources) == 0 {
+		return nil, errors.New("nothing to fetch")
+	}
 
-					log.Panicf("Got some kind of error:\n%s\n\n", prettyJ)
-				}
+	return sources, nil
+}
 
-				// Write JSON to a compressed file.
-				o, err := os.Create(outfile)
-				if err != nil {
-					log.Panic(err)
-				}
+// fetchSource fetches and stores articles from a non-archive source.
+// Article searches for past months are skipped if they have already been
+// fetched, everything else changes over time and is always re-fetched.
+func fetchSource(ctx context.Context, kc *fetch.Client, s fetch.Source, f fetch.Format) error {
+	outfile := fetch.SourceFile(*outDir, s, f)
 
-				gw := gzip.NewWriter(o)
+	if as, ok := s.(*fetch.ArticleSearch); ok && as.Month != fetch.CurrentMonth(time.Now()) {
+		if _, err := os.Stat(outfile); err == nil {
+			if *verbose {
+				fmt.Printf("Skipping existing file %s\n", outfile)
+			}
+			return nil
+		}
+	}
 
-				n, err := gw.Write(prettyJ)
-				if err != nil {
-					log.Panic(err)
-				}
+	printf(kc, "Fetching %s ..\n", s.FileName())
 
-				gw.Close()
-				o.Close()
+	articles, err := s.Fetch(ctx, kc)
+	if err != nil {
+		return err
+	}
 
-				fmt.Printf("Wrote %d / %d compressed bytes to file %s\n", n, len(prettyJ), outfile)
-				break
+	if as, ok := s.(*fetch.ArticleSearch); ok && as.Hits > len(articles) {
+		printf(kc, "Got %d of %d hits (the Article Search API returns max %d per query)\n", len(articles), as.Hits, fetch.ArticleSearchPageSize*fetch.ArticleSearchMaxPages)
+	}
+
+	if len(articles) == 0 {
+		fmt.Println("No docs returned, skipping!")
+		return nil
+	}
+
+	file, err := fetch.SaveArticles(*outDir, s, f, articles)
+	if err != nil {
+		return err
+	}
+
+	printf(kc, "Wrote %d articles to file %s\n", len(articles), file)
+	return nil
+}
+
+// verifyManifest checks every file in the manifest and returns the entries
+// of the files that need to be re-fetched.
+func verifyManifest(manifest *fetch.Manifest) (invalid []*fetch.ManifestEntry) {
+	for _, e := range manifest.Entries() {
+		err := e.Verify(*outDir)
+		if err != nil {
+			fmt.Printf("Invalid file: %s\n", err)
+			invalid = append(invalid, e)
+			continue
+		}
+		if *verbose {
+			fmt.Printf("Verified file: %s (%d articles)\n", e.File, e.ArticleCount)
+		}
+	}
+	return
+}
+
+// monthsToFetch returns the months to fetch based on the given flags.
+func monthsToFetch(manifest *fetch.Manifest) ([]fetch.Month, error) {
+	now := fetch.CurrentMonth(time.Now())
+
+	var todo []fetch.Month
+
+	if *months == "" && *year == 0 && *month == 0 && (*refreshCurrent || *refreshOlderThan > 0) {
+		// Only refresh months, picking candidates from the manifest.
+		seen := make(map[fetch.Month]bool)
+		if *refreshCurrent {
+			todo = append(todo, now)
+			seen[now] = true
+		}
+		if *refreshOlderThan > 0 {
+			for _, e := range manifest.Entries() {
+				m := fetch.Month{Year: e.Year, Month: e.Month}
+				if e.IsOpen() && !seen[m] {
+					todo = append(todo, m)
+					seen[m] = true
+				}
 			}
 		}
+	} else if *months != "" {
+		var err error
+		todo, err = fetch.ParseMonths(*months)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		from := fetch.Month{Year: *year, Month: *month}
+		if !from.Valid() {
+			return nil, errors.New("missing or invalid --year and --month args")
+		}
 
-		// Check if we're all caught up in time!
-		if *year == thisYear && *month == thisMonth {
-			fmt.Printf("We're at %d-%d - we're all caught up in time! Donezo!\n", *year, *month)
-			break
-		} else {
-			*month++
-			if *month > 12 {
-				*month = 1
-				*year++
+		to := now
+		if *toYear != 0 {
+			to = fetch.Month{Year: *toYear, Month: *toMonth}
+			if to.Month == 0 {
+				to.Month = 12
 			}
+		} else if *toMonth != 0 {
+			return nil, errors.New("--to-month requires --to-year")
+		}
+		if !to.Valid() {
+			return nil, errors.New("invalid --to-year and --to-month args")
+		}
+		if now.Before(to) {
+			to = now
 		}
+		if to.Before(from) {
+			return nil, errors.Errorf("--to-year and --to-month (%s) is before --year and --month (%s)", to, from)
+		}
+
+		todo = fetch.MonthRange(from, to)
+	}
+
+	if len(todo) == 0 {
+		return nil, errors.New("no months to fetch")
+	}
 
-		if performedAPICall {
-			fmt.Printf("Sleeping for 6 sec to avoid rate limit ..\n")
-			time.Sleep(6 * time.Second)
+	fetch.SortMonths(todo, *reverse)
+
+	return todo, nil
+}
+
+// fetchMonth fetches and stores a single month in the given format unless
+// it has already been fetched and is valid, or force is true. Months that
+// need a refresh are re-fetched and diffed against the existing file.
+func (f *fetcher) fetchMonth(ctx context.Context, kc *fetch.Client, m fetch.Month, format fetch.Format, force bool) error {
+	c := *f.c
+	c.Client = *kc
+	c.Format = format
+
+	outfile := c.OutFile(m.Year, m.Month)
+	var valid bool
+	if !force {
+		var err error
+		valid, err = f.isValid(m, outfile)
+		if err != nil {
+			return err
+		}
+	}
+	refresh := valid && f.needsRefresh(m, outfile)
+
+	if valid && !refresh {
+		return nil
+	}
+
+	var old []byte
+	if refresh {
+		var err error
+		old, err = fetch.ReadGzipFile(outfile)
+		if err != nil {
+			fmt.Printf("Could not read existing file %s (%s), re-fetching without diff ..\n", outfile, err)
 		}
 	}
+
+	printf(kc, "Fetching URL: %s\n", c.URL(m.Year, m.Month))
+
+	data, err := c.FetchRaw(ctx, m.Year, m.Month)
+	if err != nil {
+		return err
+	}
+
+	docs, err := fetch.SplitDocs(data)
+	if err != nil {
+		return errors.Wrapf(err, "could not decode response:\n%.1000s", data)
+	}
+
+	if len(docs) == 0 {
+		printf(kc, "No docs returned, skipping this year and month!\n")
+		return nil
+	}
+
+	// Write JSON to a compressed file.
+	n, err := c.Save(m.Year, m.Month, data)
+	if err != nil {
+		return err
+	}
+
+	printf(kc, "Wrote %d articles (%d bytes, %s) to file %s\n", len(docs), n, c.Format, outfile)
+
+	if old != nil {
+		d, err := fetch.DiffDocs(old, data)
+		if err != nil {
+			fmt.Printf("Could not diff refreshed file %s: %s\n", outfile, err)
+		} else {
+			fmt.Printf("Refreshed %s: %d added, %d changed, %d removed, %d unchanged\n", m, d.Added, d.Changed, d.Removed, d.Unchanged)
+		}
+	}
+
+	return f.record(m, outfile, time.Now())
+}
+
+// needsRefresh returns true if the month has been fetched before but
+// should be re-fetched as per --refresh-current or --refresh-older-than.
+func (f *fetcher) needsRefresh(m fetch.Month, outfile string) bool {
+	e, found := f.manifest.Get(outfile)
+	if !found {
+		return false
+	}
+
+	if *refreshCurrent && m == fetch.CurrentMonth(f.now) {
+		return true
+	}
+
+	return *refreshOlderThan > 0 && e.IsOpen() && f.now.Sub(e.FetchedAt) > *refreshOlderThan
+}
+
+// isValid returns true if the file for the given month exists and matches
+// the manifest. Existing files that aren't in the manifest yet are added to
+// it if they are valid.
+func (f *fetcher) isValid(m fetch.Month, outfile string) (bool, error) {
+	stat, err := os.Stat(outfile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, errors.Wrapf(err, "could not stat %s", outfile)
+		}
+		return false, nil
+	}
+
+	if e, found := f.manifest.Get(outfile); found {
+		if stat.Size() == e.CompressedSize {
+			return true, nil
+		}
+		fmt.Printf("File %s doesn't match manifest (size %d, expected %d), re-fetching ..\n", outfile, stat.Size(), e.CompressedSize)
+		return false, nil
+	}
+
+	err = f.record(m, outfile, stat.ModTime())
+	if err != nil {
+		fmt.Printf("File %s is invalid (%s), re-fetching ..\n", outfile, err)
+		return false, nil
+	}
+
+	fmt.Printf("Added existing file %s to manifest\n", outfile)
+	return true, nil
+}
+
+// record describes the given file and stores it in the manifest.
+func (f *fetcher) record(m fetch.Month, outfile string, fetchedAt time.Time) error {
+	e, err := fetch.Describe(outfile)
+	if err != nil {
+		return err
+	}
+
+	e.Year = m.Year
+	e.Month = m.Month
+	e.FetchedAt = fetchedAt.UTC()
+
+	f.manifest.Put(e)
+
+	return f.manifest.Save(f.manifestFile)
 }
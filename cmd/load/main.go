@@ -2,26 +2,56 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/anrid/nytimes/pkg/domain"
 	"github.com/anrid/nytimes/pkg/loader"
+	"github.com/anrid/nytimes/pkg/search/bleve"
 	"github.com/anrid/nytimes/pkg/search/es"
+	"github.com/anrid/nytimes/pkg/search/memory"
+	"github.com/anrid/nytimes/pkg/search/sqlite"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
 var (
 	indexName = "nytimes-articles"
 
-	gzipDir     = pflag.String("dir", "data/", "Directory with GZIP files containing New York Times articles (filenames must end in `.json.gz`)")
+	gzipDir     = pflag.String("dir", "data/", "Directory (searched recursively), file or glob with New York Times articles (.json, .ndjson, optionally .gz or .zst compressed, or .tar.gz bundles; file names must contain a date, e.g. articles-YYYY-M.json.gz)")
 	startFrom   = pflag.String("start-from", "", "File to (re)start from")
 	maxBulk     = pflag.Int("max-bulk", 5_000, "Max number of docs to index in bulk")
+	maxBytes    = pflag.Int("max-bulk-bytes", 10_000_000, "Max size of a bulk in bytes (0 for no limit)")
+	flushEvery  = pflag.Duration("flush-interval", 10*time.Second, "Max time to buffer docs before sending a bulk (0 for no limit)")
 	maxDocs     = pflag.Int("max-docs", 0, "Max number of docs to index")
 	createIndex = pflag.Bool("create-index", false, "Drop and recreate a new index")
 	verbose     = pflag.BoolP("verbose", "v", false, "Verbose output")
-	useIndexer  = pflag.String("indexer", "es", "Indexer to use, available: ['es']")
+	useIndexer  = pflag.String("indexer", "es", "Indexer to use, available: ['es', 'bleve', 'sqlite', 'memory'] (memory keeps the index for the duration of the load only)")
+	indexPath   = pflag.String("path", "", "Where to store indexes when using an embedded indexer (default ./bleve-index for bleve, ./nytimes.db for sqlite)")
+	workers     = pflag.Int("workers", runtime.NumCPU(), "Number of files to decompress and decode concurrently")
+	bulkWorkers = pflag.Int("bulk-workers", 2, "Number of bulks to index concurrently")
+	unordered   = pflag.Bool("unordered", false, "Index articles as soon as they're decoded instead of in file order (faster)")
+	contOnErr   = pflag.Bool("continue-on-error", false, "Skip corrupt files and invalid articles instead of stopping")
+	from        = pflag.String("from", "", "Only index articles published on or after this date, e.g. 1999-01 or 1999-01-15")
+	to          = pflag.String("to", "", "Only index articles published up until and including this date, e.g. 2001-12 or 2001-12-31")
+	sections    = pflag.StringSlice("section", nil, "Only index articles in these sections, e.g. World,U.S.")
+	docTypes    = pflag.StringSlice("document-type", nil, "Only index articles of these document types, e.g. article")
+	keywords    = pflag.StringSlice("keyword", nil, "Only index articles tagged with any of these keyword values")
+	byline      = pflag.String("byline", "", "Only index articles whose byline contains this name")
+	resume      = pflag.Bool("resume", false, "Resume right after the last article indexed by a previous run (see --checkpoint-file)")
+	transform   = pflag.String("transform", loader.DefaultTransformers, "Comma separated chain of transformers to apply to every doc, available: "+strings.Join(loader.DefaultRegistry().Names(), ", "))
+	fields      = pflag.StringSlice("fields", nil, "Fields to keep when using the fields transformer, e.g. headline,abstract")
+	likes       = pflag.String("likes", "exp:50", "Distribution of likes when using the engagement transformer: const:N, uniform:MIN:MAX, exp:MEAN or zipf:S:MAX")
+	comments    = pflag.String("comments", "exp:5", "Distribution of comments when using the engagement transformer")
+	checkpoint  = pflag.String("checkpoint-file", "data/.checkpoint.json", "File to save a checkpoint to after every bulk (unless --unordered)")
+	deadLetter  = pflag.String("dead-letter-file", "data/dead-letter.ndjson", "File to write docs rejected by the indexer to (empty to stop on rejected docs instead)")
+	replay      = pflag.String("replay", "", "Re-index the docs in this dead-letter file and exit")
 )
 
 func main() {
@@ -36,27 +66,215 @@ func main() {
 	switch strings.ToLower(*useIndexer) {
 	case "es":
 		indexer = es.New(nil, *verbose)
+	case "bleve":
+		indexer = bleve.New(pathOr("./bleve-index"), *verbose)
+	case "sqlite":
+		indexer = sqlite.New(pathOr("./nytimes.db"), *verbose)
+	case "memory":
+		indexer = memory.New()
 	default:
 		pflag.Usage()
 		log.Fatalf("incorrect --indexer arg")
 	}
+	defer indexer.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	fromTime, _, err := parseDate(*from)
+	if err != nil {
+		log.Fatalf("invalid --from arg: %s", err)
+	}
+	toTime, toEnd, err := parseDate(*to)
+	if err != nil {
+		log.Fatalf("invalid --to arg: %s", err)
+	}
+	if !toTime.IsZero() {
+		toTime = toEnd
+	}
+
+	var filters []loader.Filter
+	if len(*sections) > 0 {
+		filters = append(filters, loader.BySection(*sections...))
+	}
+	if len(*docTypes) > 0 {
+		filters = append(filters, loader.ByDocumentType(*docTypes...))
+	}
+	if len(*keywords) > 0 {
+		filters = append(filters, loader.ByKeyword(*keywords...))
+	}
+	if *byline != "" {
+		filters = append(filters, loader.ByByline(*byline))
+	}
+
+	likesDist, err := loader.ParseDistribution(*likes)
+	if err != nil {
+		log.Fatalf("invalid --likes arg: %s", err)
+	}
+	commentsDist, err := loader.ParseDistribution(*comments)
+	if err != nil {
+		log.Fatalf("invalid --comments arg: %s", err)
+	}
+	registry := loader.DefaultRegistry()
+	registry.Register("engagement", &loader.Engagement{Likes: likesDist, Comments: commentsDist})
+
+	chain, err := loader.ParseTransformers(*transform, loader.TransformOptions{Registry: registry, Fields: *fields})
+	if err != nil {
+		log.Fatalf("invalid --transform arg: %s", err)
+	}
+
+	// An in-memory index is gone once we exit, so there's nothing to
+	// resume and checkpoints would only mislead a later run.
+	_, inMemory := indexer.(*memory.Memory)
+
+	var cp *loader.Checkpoint
+	if *resume {
+		if *createIndex || *startFrom != "" || *unordered {
+			log.Fatalf("--resume can't be combined with --create-index, --start-from or --unordered")
+		}
+		if inMemory {
+			log.Fatalf("--resume can't be used with --indexer memory")
+		}
+
+		cp, err = loader.LoadCheckpoint(*checkpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cp == nil {
+			log.Fatalf("no checkpoint found in %s", *checkpoint)
+		}
+		if cp.IndexName != indexName {
+			log.Fatalf("checkpoint %s is for index `%s`, not `%s`", *checkpoint, cp.IndexName, indexName)
+		}
+
+		fmt.Printf("Resuming from %s at article %d (%d articles indexed so far)\n", cp.File, cp.Offset, cp.Articles)
+	}
+
+	// Ctrl-C stops reading, flushes buffered docs and saves a checkpoint.
+	// A second Ctrl-C exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	if *createIndex {
-		indexer.CreateIndex(ctx, "./assets/mappings/nytimes/index-mappings.json", indexName)
+		createCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := indexer.CreateIndex(createCtx, "./assets/mappings/nytimes/index-mappings.json", indexName)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	ld := loader.New(indexName, *maxBulk, indexer)
+	ld.Ctx = ctx
+	ld.Workers = *bulkWorkers
+	ld.MaxBulkBytes = *maxBytes
+	ld.FlushInterval = *flushEvery
+	ld.Transform = chain
+	ld.DeadLetterFile = *deadLetter
+	if !*unordered && !inMemory {
+		ld.CheckpointFile = *checkpoint
+	}
+	if cp != nil {
+		ld.Indexed = cp.Articles
+	}
+
+	if *replay != "" {
+		n, err := ld.Replay(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Replayed %d docs, %d indexed\n", n, ld.Indexed)
+		if ld.DeadLettered > 0 {
+			fmt.Printf("%d docs were rejected again, see %s\n", ld.DeadLettered, ld.DeadLetterFile)
+		}
+		return
+	}
 
-	loader.ReadDirWithArticles(loader.ReadDirWithArticlesParams{
-		Path:        *gzipDir,
-		Suffix:      ".json.gz",
-		Verbose:     *verbose,
-		StartFrom:   *startFrom,
-		Max:         *maxDocs,
-		EachArticle: ld.IndexArticle,
+	sum, err := loader.ReadDirWithArticles(loader.ReadDirWithArticlesParams{
+		Ctx:       ctx,
+		Path:      *gzipDir,
+		Verbose:   *verbose,
+		StartFrom: *startFrom,
+		Max:       *maxDocs,
+		Workers:   *workers,
+		Unordered: *unordered,
+		Resume:    cp,
+
+		// Never read back our own output files, which live in the data
+		// dir by default.
+		Exclude: []string{*checkpoint, *deadLetter},
+
+		EachArticleAt: ld.IndexArticleAt,
+
+		ContinueOnError: *contOnErr,
+		From:            fromTime,
+		To:              toTime,
+		Filter:          loader.All(filters...),
 	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+
+	if len(sum.UnknownFiles) > 0 && !*verbose {
+		fmt.Printf("Skipped %d files without a date in their names, see --verbose\n", len(sum.UnknownFiles))
+	}
+	for _, err := range sum.Errors {
+		fmt.Printf("Skipped: %v\n", err)
+	}
+	if sum.Filtered > 0 {
+		fmt.Printf("Filtered out %d articles\n", sum.Filtered)
+	}
+	if ld.DeadLettered > 0 {
+		fmt.Printf("%d docs were rejected by the indexer, see %s (and --replay)\n", ld.DeadLettered, ld.DeadLetterFile)
+	}
+
+	if err != nil {
+		fmt.Printf("Interrupted after indexing %d articles in total\n", ld.Indexed)
+		if ld.CheckpointFile != "" {
+			fmt.Printf("Checkpoint saved to %s, run again with --resume to continue\n", ld.CheckpointFile)
+		}
+		indexer.Close()
+		os.Exit(1)
+	}
+
+	countCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
+	err = indexer.Refresh(countCtx, indexName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := indexer.Count(countCtx, indexName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Index `%s` now has %d docs\n", indexName, count)
+}
+
+// pathOr returns the --path arg, or def if it's not set.
+func pathOr(def string) string {
+	if *indexPath != "" {
+		return *indexPath
+	}
+	return def
+}
+
+// parseDate parses a `YYYY-MM` or `YYYY-MM-DD` date and returns it along
+// with the end of the month or day it refers to.
+func parseDate(s string) (start, end time.Time, err error) {
+	if s == "" {
+		return
+	}
+	if start, err = time.Parse("2006-01-02", s); err == nil {
+		return start, start.AddDate(0, 0, 1), nil
+	}
+	if start, err = time.Parse("2006-01", s); err == nil {
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return start, end, errors.Errorf("expected YYYY-MM or YYYY-MM-DD, got `%s`", s)
 }
@@ -6,10 +6,14 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/search/bleve"
 	"github.com/anrid/nytimes/pkg/search/es"
+	"github.com/anrid/nytimes/pkg/search/sqlite"
 	"github.com/anrid/nytimes/pkg/util"
 	"github.com/spf13/pflag"
 )
@@ -21,6 +25,8 @@ var (
 	dumpResult = pflag.Bool("dump", false, "dump search engine result of first query")
 	queryJSON  = pflag.String("query", "./assets/mappings/nytimes/query-simple.json", "query to run (path to JSON file)")
 	numThreads = pflag.Int("threads", 10, "number of threads to run benchmark in concurrently")
+	useEngine  = pflag.String("indexer", "es", "search engine to use, available: ['es', 'bleve', 'sqlite']")
+	indexPath  = pflag.String("path", "", "where indexes are stored when using an embedded search engine (default ./bleve-index for bleve, ./nytimes.db for sqlite)")
 )
 
 func main() {
@@ -29,26 +35,50 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 1_000*time.Millisecond)
 	defer cancel()
 
-	s := es.New([]string{
-		"http://localhost:9200",
-		"http://localhost:9201",
-		"http://localhost:9202",
-	}, true)
+	var s domain.Searcher
+	var esClient *es.ES
+
+	switch strings.ToLower(*useEngine) {
+	case "es":
+		esClient = es.New([]string{
+			"http://localhost:9200",
+			"http://localhost:9201",
+			"http://localhost:9202",
+		}, true)
+		s = &es.Searcher{ES: esClient, UseCache: *useCache}
+	case "bleve":
+		b := bleve.New(pathOr("./bleve-index"), true)
+		defer b.Close()
+		s = b
+	case "sqlite":
+		db := sqlite.New(pathOr("./nytimes.db"), true)
+		defer db.Close()
+		s = db
+	default:
+		pflag.Usage()
+		log.Fatalf("incorrect --indexer arg")
+	}
 
 	// Load query from JSON file.
 	query := es.ReadJSONFile(*queryJSON)
 	fmt.Printf("Query payload:\n%s\n", string(query))
 
-	statsBefore := s.Stats(ctx)
+	var statsBefore es.StatsResponse
+	if esClient != nil {
+		statsBefore = esClient.Stats(ctx)
+	}
 
 	t := time.Now()
-	hits := s.Search(ctx, query, *indexName, *useCache)
+	hits, err := s.Search(ctx, *indexName, query)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	if *dumpResult {
 		util.Dump(hits)
 	}
 
-	fmt.Printf("Completed first request in %s\n", time.Since(t))
+	fmt.Printf("Completed first request in %s (%d hits)\n", time.Since(t), hits.Total)
 
 	if *count < 1 {
 		fmt.Printf("count = 0, exiting!\n")
@@ -75,9 +105,12 @@ func main() {
 			for i := 0; i < *count; i++ {
 				t0 := time.Now()
 
-				hits2 := s.Search(ctx, query, *indexName, *useCache)
-				if len(hits) != len(hits2) {
-					log.Panicf("hits size is %d but expected %d", len(hits2), len(hits))
+				hits2, err := s.Search(ctx, *indexName, query)
+				if err != nil {
+					log.Panic(err)
+				}
+				if len(hits.Hits) != len(hits2.Hits) {
+					log.Panicf("hits size is %d but expected %d", len(hits2.Hits), len(hits.Hits))
 				}
 
 				durations = append(durations, time.Since(t0))
@@ -115,7 +148,11 @@ func main() {
 		float64(totalReqs)/time.Since(t).Seconds(),
 	)
 
-	statsAfter := s.Stats(ctx)
+	if esClient == nil {
+		return
+	}
+
+	statsAfter := esClient.Stats(ctx)
 
 	qcMissDiff := statsAfter.All.Total.QueryCache.MissCount - statsBefore.All.Total.QueryCache.MissCount
 	qcHitsDiff := statsAfter.All.Total.QueryCache.HitCount - statsBefore.All.Total.QueryCache.HitCount
@@ -125,3 +162,11 @@ func main() {
 	fmt.Printf("Query cache   : +%-3d hits / +%-3d miss\n", qcHitsDiff, qcMissDiff)
 	fmt.Printf("Request cache : +%-3d hits / +%-3d miss\n", rcHitsDiff, rcMissDiff)
 }
+
+// pathOr returns the --path arg, or def if it's not set.
+func pathOr(def string) string {
+	if *indexPath != "" {
+		return *indexPath
+	}
+	return def
+}
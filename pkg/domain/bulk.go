@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// BulkStats tracks the bulk indexing throughput of an indexer. The zero
+// value is ready to use. Safe for concurrent use.
+type BulkStats struct {
+	mu         sync.Mutex
+	docs       int64
+	secs       float64
+	latestRate float64
+}
+
+// Add records a bulk of n docs indexed in elapsed time.
+func (s *BulkStats) Add(n int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docs += int64(n)
+	s.secs += elapsed.Seconds()
+	if elapsed > 0 {
+		s.latestRate = float64(n) / elapsed.Seconds()
+	}
+}
+
+// Rates returns the rate of the latest bulk and the average rate of all
+// bulks so far, in docs per second. Both are zero until a bulk has been
+// recorded.
+func (s *BulkStats) Rates() (latest, avg float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.secs == 0 {
+		return s.latestRate, 0
+	}
+	return s.latestRate, float64(s.docs) / s.secs
+}
+
+// Print prints the bulk indexing rates, see Rates.
+func (s *BulkStats) Print() {
+	latest, avg := s.Rates()
+	fmt.Printf("Bulk indexing rate: %.02f docs / sec  (avg: %.02f)\n", latest, avg)
+}
+
+// CheckBulk returns an error unless there's a doc for every doc ID in a
+// non-empty bulk.
+func CheckBulk(docIDs []string, docs []interface{}) error {
+	if len(docIDs) == 0 || len(docIDs) != len(docs) {
+		return errors.Errorf("got %d doc IDs but %d docs", len(docIDs), len(docs))
+	}
+	return nil
+}
+
+// DecodeDoc decodes a doc handed to an indexer into v. Docs are usually
+// *SearchArticle, but docs buffered serialized by the loader or replayed
+// from a dead-letter file are json.RawMessage.
+func DecodeDoc(doc interface{}, v interface{}) error {
+	data, ok := doc.(json.RawMessage)
+	if !ok {
+		var err error
+		data, err = json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// InvalidDoc returns the failure reported for a doc an indexer can't
+// decode or index.
+func InvalidDoc(pos int, docID string, err error) BulkFailure {
+	return BulkFailure{
+		Pos:    pos,
+		DocID:  docID,
+		Status: 400,
+		Type:   "invalid_doc",
+		Reason: err.Error(),
+	}
+}
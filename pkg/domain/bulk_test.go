@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkStats(t *testing.T) {
+	r := require.New(t)
+
+	var s BulkStats
+
+	// No NaN before the first bulk.
+	latest, avg := s.Rates()
+	r.Zero(latest)
+	r.Zero(avg)
+
+	s.Add(100, time.Second)
+	s.Add(300, time.Second)
+
+	latest, avg = s.Rates()
+	r.Equal(300.0, latest)
+	r.Equal(200.0, avg)
+}
+
+func TestDecodeDoc(t *testing.T) {
+	r := require.New(t)
+
+	r.Error(CheckBulk(nil, nil))
+	r.Error(CheckBulk([]string{"1"}, nil))
+	r.NoError(CheckBulk([]string{"1"}, []interface{}{&SearchArticle{ID: "1"}}))
+
+	var sa SearchArticle
+	r.NoError(DecodeDoc(&SearchArticle{ID: "1", Headline: "Hi"}, &sa))
+	r.Equal(SearchArticle{ID: "1", Headline: "Hi"}, sa)
+
+	var m map[string]interface{}
+	r.NoError(DecodeDoc(json.RawMessage(`{"id": "2"}`), &m))
+	r.Equal("2", m["id"])
+
+	err := DecodeDoc(json.RawMessage(`[1]`), &m)
+	r.Error(err)
+	f := InvalidDoc(2, "3", err)
+	r.Equal(2, f.Pos)
+	r.Equal("3", f.DocID)
+	r.Equal(400, f.Status)
+	r.False(f.Retriable)
+}
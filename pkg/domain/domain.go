@@ -2,16 +2,90 @@ package domain
 
 import (
 	"context"
+	"errors"
 )
 
+// ErrBulkRejected is returned by indexers when a bulk is rejected because
+// the search engine is overloaded, e.g. a 429 from ES. The bulk may be
+// retried later.
+var ErrBulkRejected = errors.New("bulk rejected by search engine")
+
+// BulkResult is the result of a bulk, listing any docs that failed.
+type BulkResult struct {
+	Indexed  int
+	Failures []BulkFailure
+}
+
+// BulkFailure describes a doc that failed to be indexed as part of a bulk.
+type BulkFailure struct {
+	Pos    int // Position of the doc in the bulk, as IDs may repeat.
+	DocID  string
+	Status int    // HTTP status of the item, e.g. 400 or 429.
+	Type   string // Error type, e.g. `mapper_parsing_exception`.
+	Reason string
+	// Retriable is true if indexing the doc may succeed later, e.g. when
+	// it was rejected because the search engine was overloaded.
+	Retriable bool
+}
+
+// Indexer is implemented by search engine backends that articles can be
+// loaded into.
 type Indexer interface {
-	CreateIndex(ctx context.Context, mappingsJSONFile, indexName string)
-	BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{})
+	// CreateIndex creates an index using the given ES style mappings file,
+	// dropping any existing index with the same name first.
+	CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error
+	// DeleteIndex deletes an index. Deleting a missing index is not an
+	// error.
+	DeleteIndex(ctx context.Context, indexName string) error
+	// BulkIndex indexes docs with the given IDs. If the whole bulk is
+	// rejected because the backend is overloaded the error wraps
+	// ErrBulkRejected. Docs that fail individually are listed in the
+	// result. The loader calls BulkIndex from several workers at once, so
+	// it must be safe for concurrent use.
+	BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*BulkResult, error)
+	// Refresh makes all docs indexed so far visible to searches. Backends
+	// where docs are visible as soon as BulkIndex returns only check that
+	// the index exists.
+	Refresh(ctx context.Context, indexName string) error
+	// Count returns the number of docs in an index.
+	Count(ctx context.Context, indexName string) (int, error)
+	// PrintBulkIndexingRate prints bulk indexing throughput so far.
 	PrintBulkIndexingRate()
+	// Close releases any resources held by the indexer.
+	Close() error
+}
+
+// Searcher is implemented by search engine backends that can run the ES
+// style JSON queries in assets/mappings.
+type Searcher interface {
+	Search(ctx context.Context, indexName string, queryJSON []byte) (*SearchResult, error)
+}
+
+// SearchResult holds the top hits of a search, in order, along with the
+// total number of matching docs.
+type SearchResult struct {
+	Total  int
+	Hits   []SearchHit
+	Facets map[string][]FacetTerm // Terms aggregations by name.
+}
+
+type SearchHit struct {
+	ID     string
+	Score  float64
+	Source map[string]interface{} // Stored fields, if the backend stores any.
+}
+
+type FacetTerm struct {
+	Term  string
+	Count int
 }
 
 type NYTimesMonthlyArticles struct {
-	Response struct {
+	Copyright string `json:"copyright"`
+	Response  struct {
+		Meta struct {
+			Hits int `json:"hits"`
+		} `json:"meta"`
 		Docs []*NYTimesArticle `json:"docs"`
 	} `json:"response"`
 }
@@ -31,14 +105,20 @@ type NYTimesArticle struct {
 		Main          string `json:"main"`
 		PrintHeadline string `json:"print_headline"`
 	} `json:"headline"`
-	Keywords []struct {
-		Name  string `json:"name"`
-		Rank  int64  `json:"rank"`
-		Value string `json:"value"`
-	} `json:"keywords"`
-	LeadParagraph string       `json:"lead_paragraph"`
-	Multimedia    []Multimedia `json:"multimedia"`
-	PubDate       string       `json:"pub_date"`
+	Keywords       []Keyword    `json:"keywords"`
+	LeadParagraph  string       `json:"lead_paragraph"`
+	Multimedia     []Multimedia `json:"multimedia"`
+	PubDate        string       `json:"pub_date"`
+	SectionName    string       `json:"section_name"`
+	DocumentType   string       `json:"document_type"`
+	TypeOfMaterial string       `json:"type_of_material"`
+	WebURL         string       `json:"web_url"`
+}
+
+type Keyword struct {
+	Name  string `json:"name"`
+	Rank  int64  `json:"rank"`
+	Value string `json:"value"`
 }
 
 type SearchArticle struct {
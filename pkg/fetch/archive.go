@@ -0,0 +1,293 @@
+// Fetch package handles fetching NY Times articles from the
+// NY Times public APIs.
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultAPIBaseURL     = "https://api.nytimes.com/svc"
+	DefaultArchiveBaseURL = DefaultAPIBaseURL + "/archive/v1"
+	DefaultAPIKeyEnvVar   = "SHINY_NYTIMES_API_KEY"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited by NY Times API")
+	ErrMissingKey  = errors.New("missing NY Times API key")
+)
+
+// KeySource returns the API key to use for the next request.
+type KeySource func() (string, error)
+
+// EnvKey returns a KeySource reading the API key from the given
+// env var.
+func EnvKey(name string) KeySource {
+	return func() (string, error) {
+		key := os.Getenv(name)
+		if key == "" {
+			return "", errors.Wrapf(ErrMissingKey, "env var %s not set", name)
+		}
+		return key, nil
+	}
+}
+
+// StaticKey returns a KeySource that always returns the given key.
+func StaticKey(key string) KeySource {
+	return func() (string, error) {
+		if key == "" {
+			return "", ErrMissingKey
+		}
+		return key, nil
+	}
+}
+
+// APIError is returned when the NY Times API responds with a non-200
+// status code.
+type APIError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("got status %d from NY Times API: %s", e.StatusCode, e.Body)
+}
+
+// Is makes errors.Is(err, ErrRateLimited) work for rate limit errors.
+func (e *APIError) Is(target error) bool {
+	return target == ErrRateLimited && (e.StatusCode == http.StatusTooManyRequests || IsQuotaViolation([]byte(e.Body)))
+}
+
+// Retriable returns true if the request may succeed if retried.
+func (e *APIError) Retriable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client performs GET requests against the NY Times APIs.
+//
+// If Limiter is set every request waits for it first, and if Backoff is
+// set rate limit errors, 5xx errors and network errors are retried with
+// exponential backoff.
+type Client struct {
+	Name    string // Used in log output, e.g. the API key's ID.
+	HTTP    *http.Client
+	Key     KeySource
+	Limiter *RateLimiter
+	Backoff *util.Backoff
+
+	// OnRetry is called before sleeping prior to a retry.
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+func NewClient(key KeySource) *Client {
+	return &Client{HTTP: http.DefaultClient, Key: key}
+}
+
+// Get fetches the given URL (which must not contain the API key) and
+// returns the response body as is.
+func (c *Client) Get(ctx context.Context, rawURL string, params url.Values) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if c.Limiter != nil {
+			err := c.Limiter.Wait(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := c.get(ctx, rawURL, params)
+		if err == nil {
+			return data, nil
+		}
+
+		if c.Backoff == nil || attempt >= c.Backoff.MaxRetries || !retriable(ctx, err) {
+			return nil, err
+		}
+
+		delay := c.Backoff.Delay(attempt)
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+
+		if c.OnRetry != nil {
+			c.OnRetry(attempt+1, delay, err)
+		}
+
+		err = util.Sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, rawURL string, params url.Values) ([]byte, error) {
+	key, err := c.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set("api-key", key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch %s", rawURL)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", rawURL)
+	}
+
+	if res.StatusCode != http.StatusOK || IsQuotaViolation(data) {
+		statusCode := res.StatusCode
+		if statusCode == http.StatusOK {
+			statusCode = http.StatusTooManyRequests
+		}
+
+		return nil, &APIError{
+			StatusCode: statusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+			Body:       string(data),
+		}
+	}
+
+	return data, nil
+}
+
+// ArchiveClient fetches monthly article archives from the NY Times
+// Archive API (or anything that speaks the same protocol).
+type ArchiveClient struct {
+	Client
+	BaseURL string
+	OutDir  string
+	Format  Format
+}
+
+func NewArchiveClient(key KeySource, outDir string) *ArchiveClient {
+	return &ArchiveClient{
+		Client:  *NewClient(key),
+		BaseURL: DefaultArchiveBaseURL,
+		OutDir:  outDir,
+		Format:  FormatRaw,
+	}
+}
+
+// URL returns the archive URL for the given year and month, without
+// the API key.
+func (c *ArchiveClient) URL(year, month int) string {
+	return fmt.Sprintf("%s/%d/%d.json", strings.TrimSuffix(c.BaseURL, "/"), year, month)
+}
+
+// OutFile returns the path of the file the given year and month is
+// stored in.
+func (c *ArchiveClient) OutFile(year, month int) string {
+	return filepath.Join(c.OutDir, fmt.Sprintf("articles-%d-%d", year, month)+c.Format.Ext())
+}
+
+// FetchRaw fetches the archive for the given year and month and
+// returns the response body as is.
+func (c *ArchiveClient) FetchRaw(ctx context.Context, year, month int) ([]byte, error) {
+	data, err := c.Get(ctx, c.URL(year, month), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch archive %d-%d", year, month)
+	}
+	return data, nil
+}
+
+// Fetch fetches the archive for the given year and month and decodes it.
+func (c *ArchiveClient) Fetch(ctx context.Context, year, month int) (*domain.NYTimesMonthlyArticles, error) {
+	data, err := c.FetchRaw(ctx, year, month)
+	if err != nil {
+		return nil, err
+	}
+
+	nyt := new(domain.NYTimesMonthlyArticles)
+
+	err = json.Unmarshal(data, nyt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode archive %d-%d", year, month)
+	}
+
+	return nyt, nil
+}
+
+// Save converts an archive response body to the client's format and
+// atomically writes it to the gzip compressed out file for the given year
+// and month. Returns the number of (uncompressed) bytes written.
+func (c *ArchiveClient) Save(year, month int, data []byte) (int, error) {
+	encoded, err := c.Format.Encode(data)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not encode archive %d-%d as %s", year, month, c.Format)
+	}
+
+	err = WriteGzipFileAtomic(c.OutFile(year, month), encoded)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(encoded), nil
+}
+
+// IsQuotaViolation returns true if the response body is a NY Times API
+// rate limit error.
+func IsQuotaViolation(data []byte) bool {
+	return len(data) < 1_000 && strings.Contains(string(data), "policies.ratelimit.QuotaViolation")
+}
+
+// retriable returns true for rate limit errors, 5xx errors and network
+// errors.
+func retriable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrMissingKey) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retriable()
+	}
+
+	return true
+}
+
+// parseRetryAfter parses a `Retry-After` header given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
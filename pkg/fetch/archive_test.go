@@ -0,0 +1,118 @@
+package fetch
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveClient(t *testing.T) {
+	r := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("api-key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/2022/12.json":
+			w.Write([]byte(`{"response":{"docs":[{"_id":"nyt://article/1","headline":{"main":"Hello"},"pub_date":"2022-12-01T00:00:00+0000"}]}}`))
+		case "/2023/1.json":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"fault":{"faultstring":"Rate limit quota violation","detail":{"errorcode":"policies.ratelimit.QuotaViolation"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewArchiveClient(StaticKey("secret"), t.TempDir())
+	c.BaseURL = srv.URL
+
+	nyt, err := c.Fetch(context.Background(), 2022, 12)
+	r.NoError(err)
+	r.Len(nyt.Response.Docs, 1)
+	r.Equal("nyt://article/1", nyt.Response.Docs[0].ID)
+	r.Equal("Hello", nyt.Response.Docs[0].Headline.Main)
+
+	_, err = c.FetchRaw(context.Background(), 2023, 1)
+	r.ErrorIs(err, ErrRateLimited)
+
+	_, err = c.FetchRaw(context.Background(), 2023, 2)
+	r.Error(err)
+
+	c.Key = StaticKey("")
+	_, err = c.FetchRaw(context.Background(), 2022, 12)
+	r.ErrorIs(err, ErrMissingKey)
+
+	n, err := c.Save(2022, 12, []byte(`{"response":{"docs":[]}}`))
+	r.NoError(err)
+	r.Equal(24, n)
+
+	f, err := os.Open(c.OutFile(2022, 12))
+	r.NoError(err)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	r.NoError(err)
+
+	data, err := io.ReadAll(gr)
+	r.NoError(err)
+	r.Equal(`{"response":{"docs":[]}}`, string(data))
+}
+
+func TestArchiveClientRetries(t *testing.T) {
+	r := require.New(t)
+
+	var calls int
+	var unauthorized bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		switch {
+		case unauthorized:
+			w.WriteHeader(http.StatusUnauthorized)
+		case calls == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case calls == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"response":{"docs":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	var retries []int
+
+	c := NewArchiveClient(StaticKey("secret"), t.TempDir())
+	c.BaseURL = srv.URL
+	c.Backoff = &util.Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond, MaxRetries: 2}
+	c.OnRetry = func(attempt int, delay time.Duration, err error) {
+		retries = append(retries, attempt)
+	}
+
+	data, err := c.FetchRaw(context.Background(), 2022, 12)
+	r.NoError(err)
+	r.Equal(`{"response":{"docs":[]}}`, string(data))
+	r.Equal([]int{1, 2}, retries)
+
+	// Client errors are not retried.
+	calls = 0
+	unauthorized = true
+
+	_, err = c.FetchRaw(context.Background(), 2022, 12)
+	var apiErr *APIError
+	r.ErrorAs(err, &apiErr)
+	r.Equal(http.StatusUnauthorized, apiErr.StatusCode)
+	r.Equal(1, calls)
+}
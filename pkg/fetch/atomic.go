@@ -0,0 +1,60 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"os"
+
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// WriteGzipFileAtomic writes data to a gzip compressed file atomically,
+// validating the gzip stream by decompressing it and comparing it to data
+// before renaming the temp file into place.
+func WriteGzipFileAtomic(file string, data []byte) error {
+	write := func(w io.Writer) error {
+		gw := gzip.NewWriter(w)
+
+		_, err := gw.Write(data)
+		if err != nil {
+			return err
+		}
+
+		return gw.Close()
+	}
+
+	validate := func(tmpFile string) error {
+		f, err := os.Open(tmpFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+
+		n, err := io.Copy(h, gr)
+		if err != nil {
+			return err
+		}
+		if n != int64(len(data)) {
+			return errors.Errorf("read back %d bytes, expected %d", n, len(data))
+		}
+
+		expected := sha256.Sum256(data)
+		if !bytes.Equal(h.Sum(nil), expected[:]) {
+			return errors.New("checksum of data read back doesn't match")
+		}
+
+		return gr.Close()
+	}
+
+	return util.WriteFileAtomic(file, write, validate)
+}
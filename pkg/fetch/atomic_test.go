@@ -0,0 +1,28 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteGzipFileAtomic(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "articles-2023-1.json.gz")
+	data := []byte(`{"response":{"docs":[]}}`)
+
+	r.NoError(WriteGzipFileAtomic(file, data))
+
+	read, err := ReadGzipFile(file)
+	r.NoError(err)
+	r.Equal(data, read)
+
+	// Only the file itself is left behind.
+	des, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(des, 1)
+}
@@ -0,0 +1,118 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"os"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// DocDiff summarizes the difference between two sets of articles.
+type DocDiff struct {
+	Added     int
+	Changed   int
+	Removed   int
+	Unchanged int
+}
+
+// DiffDocs compares the docs in two monthly archive responses by `_id`.
+// Docs are compared by content, ignoring key order and formatting. Returns
+// an error if any doc lacks an `_id`.
+func DiffDocs(oldData, newData []byte) (*DocDiff, error) {
+	oldDocs, err := docHashes(oldData)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read old docs")
+	}
+	newDocs, err := docHashes(newData)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read new docs")
+	}
+
+	d := new(DocDiff)
+
+	for id, h := range newDocs {
+		oh, found := oldDocs[id]
+		switch {
+		case !found:
+			d.Added++
+		case oh != h:
+			d.Changed++
+		default:
+			d.Unchanged++
+		}
+	}
+	for id := range oldDocs {
+		if _, found := newDocs[id]; !found {
+			d.Removed++
+		}
+	}
+
+	return d, nil
+}
+
+// docHashes returns a map of doc ID to a hash of the doc's content.
+func docHashes(data []byte) (map[string][32]byte, error) {
+	docs, err := SplitDocs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string][32]byte, len(docs))
+
+	for i, raw := range docs {
+		var doc map[string]interface{}
+
+		// Keep numbers as they are, as float64 would round IDs and counts
+		// above 2^53.
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+
+		err = dec.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+
+		// Docs without an ID can't be matched up between the two sets.
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			return nil, errors.Errorf("doc %d has no `_id`", i)
+		}
+
+		// Map keys are marshalled in sorted order which gives us a
+		// canonical form of the doc.
+		canonical, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[id] = sha256.Sum256(canonical)
+	}
+
+	return hashes, nil
+}
+
+// ReadGzipFile reads and decompresses a gzip compressed file.
+func ReadGzipFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read gzip header of %s", file)
+	}
+	defer gr.Close()
+
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", file)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,47 @@
+package fetch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffDocs(t *testing.T) {
+	r := require.New(t)
+
+	old := []byte(`{
+  "response": {
+    "docs": [
+      {"_id": "1", "abstract": "a", "headline": {"main": "One", "print_headline": ""}},
+      {"_id": "2", "abstract": "b"},
+      {"_id": "3", "abstract": "c"}
+    ]
+  }
+}`)
+	newData := []byte(`{"response":{"docs":[{"headline":{"print_headline":"","main":"One"},"_id":"1","abstract":"a"},{"_id":"2","abstract":"B"},{"_id":"4"}]}}`)
+
+	d, err := DiffDocs(old, newData)
+	r.NoError(err)
+	r.Equal(&DocDiff{Added: 1, Changed: 1, Removed: 1, Unchanged: 1}, d)
+
+	// Numbers beyond float64 precision are compared exactly.
+	d, err = DiffDocs(
+		[]byte(`{"response":{"docs":[{"_id":"1","word_count":9007199254740993}]}}`),
+		[]byte(`{"response":{"docs":[{"_id":"1","word_count":9007199254740992}]}}`),
+	)
+	r.NoError(err)
+	r.Equal(&DocDiff{Changed: 1}, d)
+
+	// Docs without an ID can't be diffed.
+	_, err = DiffDocs(
+		[]byte(`{"response":{"docs":[{"_id":"1"}]}}`),
+		[]byte(`{"response":{"docs":[{"_id":"1"},{"abstract":"a"},{"abstract":"b"}]}}`),
+	)
+	r.ErrorContains(err, "could not read new docs: doc 1 has no `_id`")
+
+	e := &ManifestEntry{Year: 2023, Month: 2, FetchedAt: time.Date(2023, 2, 28, 23, 0, 0, 0, time.UTC)}
+	r.True(e.IsOpen())
+	e.FetchedAt = time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	r.False(e.IsOpen())
+}
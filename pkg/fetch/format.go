@@ -0,0 +1,131 @@
+package fetch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Format is the format fetched articles are stored in.
+type Format string
+
+const (
+	FormatRaw     Format = "raw"     // The API response body as is.
+	FormatCompact Format = "compact" // The API response body without insignificant whitespace.
+	FormatPretty  Format = "pretty"  // The API response body, indented.
+	FormatNDJSON  Format = "ndjson"  // One article per line.
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatRaw, FormatCompact, FormatPretty, FormatNDJSON:
+		return f, nil
+	}
+	return "", errors.Errorf("invalid format `%s`, expected raw, compact, pretty or ndjson", s)
+}
+
+// Ext returns the file extension used for files in this format.
+func (f Format) Ext() string {
+	if f == FormatNDJSON {
+		return ".ndjson.gz"
+	}
+	return ".json.gz"
+}
+
+// FileFormat returns the format a file was stored in, judging by its
+// extension. Files ending in `.json.gz` may be in any of the JSON formats,
+// so preferred is returned if it's one of them and FormatRaw otherwise.
+func FileFormat(file string, preferred Format) Format {
+	if strings.HasSuffix(file, FormatNDJSON.Ext()) {
+		return FormatNDJSON
+	}
+	if preferred != FormatNDJSON && preferred != "" {
+		return preferred
+	}
+	return FormatRaw
+}
+
+// Encode converts an API response body to this format. Key order and
+// number precision are preserved in all formats.
+//
+// Uses encoding/json rather than go-json as its Compact and Indent work
+// on the raw bytes without decoding them.
+func (f Format) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch f {
+	case FormatRaw, "":
+		return data, nil
+	case FormatCompact:
+		err := json.Compact(&buf, data)
+		return buf.Bytes(), err
+	case FormatPretty:
+		err := json.Indent(&buf, data, "", "  ")
+		return buf.Bytes(), err
+	case FormatNDJSON:
+		docs, err := SplitDocs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			err = json.Compact(&buf, doc)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, errors.Errorf("invalid format `%s`", f)
+}
+
+// SplitDocs returns the raw docs in either an API response body or in
+// NDJSON with one article per line.
+func SplitDocs(data []byte) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var first json.RawMessage
+
+	err := dec.Decode(&first)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Response *struct {
+			Docs []json.RawMessage `json:"docs"`
+		} `json:"response"`
+	}
+
+	err = json.Unmarshal(first, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Response != nil {
+		return res.Response.Docs, nil
+	}
+
+	// NDJSON, the first value is the first doc.
+	docs := []json.RawMessage{first}
+
+	for {
+		var doc json.RawMessage
+
+		err = dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, doc)
+	}
+}
@@ -0,0 +1,43 @@
+package fetch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormats(t *testing.T) {
+	r := require.New(t)
+
+	data := []byte(`{"status": "OK", "response": {"docs": [{"_id": "1", "word_count": 12345678901234567890}, {"_id": "2", "abstract": "b"}], "meta": {"hits": 2}}}`)
+
+	raw, err := FormatRaw.Encode(data)
+	r.NoError(err)
+	r.Equal(data, raw)
+
+	compact, err := FormatCompact.Encode(data)
+	r.NoError(err)
+	r.Equal(`{"status":"OK","response":{"docs":[{"_id":"1","word_count":12345678901234567890},{"_id":"2","abstract":"b"}],"meta":{"hits":2}}}`, string(compact))
+
+	pretty, err := FormatPretty.Encode(data)
+	r.NoError(err)
+	r.Contains(string(pretty), "\n  \"response\": {\n")
+
+	ndjson, err := FormatNDJSON.Encode(data)
+	r.NoError(err)
+	r.Equal("{\"_id\":\"1\",\"word_count\":12345678901234567890}\n{\"_id\":\"2\",\"abstract\":\"b\"}\n", string(ndjson))
+	r.Equal(".ndjson.gz", FormatNDJSON.Ext())
+
+	for _, encoded := range [][]byte{raw, compact, pretty, ndjson} {
+		docs, err := SplitDocs(encoded)
+		r.NoError(err)
+		r.Len(docs, 2)
+	}
+
+	r.Equal(FormatNDJSON, FileFormat("articles-2014-4.ndjson.gz", FormatPretty))
+	r.Equal(FormatPretty, FileFormat("articles-2014-4.json.gz", FormatPretty))
+	r.Equal(FormatRaw, FileFormat("articles-2014-4.json.gz", FormatNDJSON))
+
+	_, err = ParseFormat("yaml")
+	r.Error(err)
+}
@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseKeys splits a comma separated list of API keys.
+func ParseKeys(s string) []string {
+	var keys []string
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// ReadKeyFile reads API keys from a file with one key per line. Empty
+// lines and lines starting with `#` are ignored.
+func ReadKeyFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open key file %s", file)
+	}
+	defer f.Close()
+
+	var keys []string
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not read key file %s", file)
+	}
+
+	return keys, nil
+}
+
+// KeyID returns a short, stable ID for an API key that is safe to print
+// and use in file names.
+func KeyID(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:4])
+}
@@ -0,0 +1,221 @@
+package fetch
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+const ManifestFile = "manifest.json"
+
+// Manifest records every fetched file in a data dir, keyed by file name.
+// It's safe for concurrent use.
+type Manifest struct {
+	Files map[string]*ManifestEntry `json:"files"`
+
+	mu sync.Mutex
+}
+
+type ManifestEntry struct {
+	File             string    `json:"file"`
+	Year             int       `json:"year"`
+	Month            int       `json:"month"`
+	SHA256           string    `json:"sha256"`
+	CompressedSize   int64     `json:"compressed_size"`
+	UncompressedSize int64     `json:"uncompressed_size"`
+	ArticleCount     int       `json:"article_count"`
+	FetchedAt        time.Time `json:"fetched_at"`
+	Hits             int       `json:"hits"`      // Number of hits reported by the API.
+	Copyright        string    `json:"copyright"` // Copyright notice returned by the API.
+}
+
+// LoadManifest loads a manifest from file, returning an empty manifest if
+// the file doesn't exist.
+func LoadManifest(file string) (*Manifest, error) {
+	m := &Manifest{Files: make(map[string]*ManifestEntry)}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, errors.Wrapf(err, "could not read manifest %s", file)
+	}
+
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode manifest %s", file)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]*ManifestEntry)
+	}
+
+	return m, nil
+}
+
+// Save writes the manifest to file.
+func (m *Manifest) Save(file string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, nil)
+}
+
+// Put adds or replaces the entry for e.File.
+func (m *Manifest) Put(e *ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Files[e.File] = e
+}
+
+// Get returns the entry for the given file name, if any.
+func (m *Manifest) Get(file string) (*ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, found := m.Files[filepath.Base(file)]
+	return e, found
+}
+
+// Entries returns all entries sorted by year and month.
+func (m *Manifest) Entries() []*ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []*ManifestEntry
+	for _, e := range m.Files {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Year != entries[j].Year {
+			return entries[i].Year < entries[j].Year
+		}
+		if entries[i].Month != entries[j].Month {
+			return entries[i].Month < entries[j].Month
+		}
+		return entries[i].File < entries[j].File
+	})
+
+	return entries
+}
+
+// IsOpen returns true if the file was fetched before the month ended, i.e.
+// the archive may have grown since.
+func (e *ManifestEntry) IsOpen() bool {
+	return e.FetchedAt.Before(Month{Year: e.Year, Month: e.Month}.Next().Start())
+}
+
+// Describe reads a gzip compressed file with either a monthly archive
+// response or NDJSON and returns a manifest entry describing it. Returns an
+// error if the file is truncated or isn't valid.
+func Describe(file string) (*ManifestEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	compressed := &countingReader{r: io.TeeReader(f, h)}
+
+	gr, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read gzip header of %s", file)
+	}
+	defer gr.Close()
+
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", file)
+	}
+
+	// Drain the rest of the file to make sure that the checksum covers
+	// the whole file.
+	_, err = io.Copy(io.Discard, compressed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", file)
+	}
+
+	docs, err := SplitDocs(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode %s", file)
+	}
+
+	e := &ManifestEntry{
+		File:             filepath.Base(file),
+		SHA256:           hex.EncodeToString(h.Sum(nil)),
+		CompressedSize:   compressed.n,
+		UncompressedSize: int64(len(data)),
+		ArticleCount:     len(docs),
+	}
+
+	var meta struct {
+		Copyright string `json:"copyright"`
+		Response  *struct {
+			Meta struct {
+				Hits int `json:"hits"`
+			} `json:"meta"`
+		} `json:"response"`
+	}
+
+	// NDJSON files don't have any response metadata.
+	if json.Unmarshal(data, &meta) == nil && meta.Response != nil {
+		e.Hits = meta.Response.Meta.Hits
+		e.Copyright = meta.Copyright
+	}
+
+	return e, nil
+}
+
+// Verify checks that the file described by e exists and matches its
+// checksum, sizes and article count.
+func (e *ManifestEntry) Verify(dir string) error {
+	got, err := Describe(filepath.Join(dir, e.File))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case got.SHA256 != e.SHA256:
+		return errors.Errorf("%s: checksum mismatch, expected %s got %s", e.File, e.SHA256, got.SHA256)
+	case got.CompressedSize != e.CompressedSize:
+		return errors.Errorf("%s: compressed size mismatch, expected %d got %d", e.File, e.CompressedSize, got.CompressedSize)
+	case got.UncompressedSize != e.UncompressedSize:
+		return errors.Errorf("%s: uncompressed size mismatch, expected %d got %d", e.File, e.UncompressedSize, got.UncompressedSize)
+	case got.ArticleCount != e.ArticleCount:
+		return errors.Errorf("%s: article count mismatch, expected %d got %d", e.File, e.ArticleCount, got.ArticleCount)
+	}
+
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	c := NewArchiveClient(StaticKey("secret"), dir)
+
+	data := []byte(`{"copyright":"Copyright (c) 2023 The New York Times Company. All Rights Reserved.","response":{"meta":{"hits":2},"docs":[{"_id":"nyt://article/1"},{"_id":"nyt://article/2"}]}}`)
+
+	_, err := c.Save(2023, 1, data)
+	r.NoError(err)
+
+	e, err := Describe(c.OutFile(2023, 1))
+	r.NoError(err)
+	r.Equal("articles-2023-1.json.gz", e.File)
+	r.Equal(int64(len(data)), e.UncompressedSize)
+	r.Equal(2, e.ArticleCount)
+	r.Equal(2, e.Hits)
+	r.Len(e.SHA256, 64)
+	r.NoError(e.Verify(dir))
+
+	m, err := LoadManifest(filepath.Join(dir, ManifestFile))
+	r.NoError(err)
+	r.Empty(m.Files)
+
+	e.Year, e.Month = 2023, 1
+	m.Put(e)
+	r.NoError(m.Save(filepath.Join(dir, ManifestFile)))
+
+	m, err = LoadManifest(filepath.Join(dir, ManifestFile))
+	r.NoError(err)
+	r.Equal([]*ManifestEntry{e}, m.Entries())
+
+	// Truncate the file.
+	gz, err := os.ReadFile(c.OutFile(2023, 1))
+	r.NoError(err)
+	r.NoError(os.WriteFile(c.OutFile(2023, 1), gz[:len(gz)/2], 0o644))
+
+	_, found := m.Get(c.OutFile(2023, 1))
+	r.True(found)
+	r.Error(e.Verify(dir))
+
+	// Replace it with a different, valid file.
+	_, err = c.Save(2023, 1, []byte(`{"response":{"docs":[{"_id":"nyt://article/1"}]}}`))
+	r.NoError(err)
+	r.ErrorContains(e.Verify(dir), "checksum mismatch")
+}
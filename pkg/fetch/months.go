@@ -0,0 +1,115 @@
+package fetch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Month is a single year and month in the archive.
+type Month struct {
+	Year  int
+	Month int
+}
+
+// CurrentMonth returns the month of the given time.
+func CurrentMonth(t time.Time) Month {
+	return Month{Year: t.Year(), Month: int(t.Month())}
+}
+
+// ParseMonth parses months formatted as `YYYY-M` or `YYYY-MM`.
+func ParseMonth(s string) (Month, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return Month{}, errors.Errorf("invalid month `%s`, expected YYYY-MM", s)
+	}
+
+	year, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Month{}, errors.Errorf("invalid year in month `%s`", s)
+	}
+	month, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Month{}, errors.Errorf("invalid month in month `%s`", s)
+	}
+
+	m := Month{Year: year, Month: month}
+	if !m.Valid() {
+		return Month{}, errors.Errorf("invalid month `%s`, expected YYYY-MM", s)
+	}
+
+	return m, nil
+}
+
+// ParseMonths parses a comma separated list of months, e.g.
+// `1999-01,2001-07`. Duplicate months are dropped.
+func ParseMonths(s string) ([]Month, error) {
+	var months []Month
+	seen := make(map[Month]bool)
+
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		m, err := ParseMonth(part)
+		if err != nil {
+			return nil, err
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		months = append(months, m)
+	}
+
+	return months, nil
+}
+
+func (m Month) Valid() bool {
+	return m.Year > 0 && m.Month >= 1 && m.Month <= 12
+}
+
+func (m Month) Next() Month {
+	if m.Month == 12 {
+		return Month{Year: m.Year + 1, Month: 1}
+	}
+	return Month{Year: m.Year, Month: m.Month + 1}
+}
+
+// Start returns the first instant of the month in UTC.
+func (m Month) Start() time.Time {
+	return time.Date(m.Year, time.Month(m.Month), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (m Month) Before(o Month) bool {
+	return m.Year < o.Year || (m.Year == o.Year && m.Month < o.Month)
+}
+
+func (m Month) String() string {
+	return fmt.Sprintf("%d-%d", m.Year, m.Month)
+}
+
+// MonthRange returns all months from `from` up to and including `to`.
+func MonthRange(from, to Month) []Month {
+	var months []Month
+	for m := from; !to.Before(m); m = m.Next() {
+		months = append(months, m)
+	}
+	return months
+}
+
+// SortMonths sorts months in chronological order, or reverse
+// chronological order if reverse is true.
+func SortMonths(months []Month, reverse bool) {
+	sort.Slice(months, func(i, j int) bool {
+		if reverse {
+			return months[j].Before(months[i])
+		}
+		return months[i].Before(months[j])
+	})
+}
@@ -0,0 +1,34 @@
+package fetch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonths(t *testing.T) {
+	r := require.New(t)
+
+	ms, err := ParseMonths("2001-07, 1999-01,1999-12")
+	r.NoError(err)
+	r.Equal([]Month{{2001, 7}, {1999, 1}, {1999, 12}}, ms)
+
+	SortMonths(ms, false)
+	r.Equal([]Month{{1999, 1}, {1999, 12}, {2001, 7}}, ms)
+
+	SortMonths(ms, true)
+	r.Equal([]Month{{2001, 7}, {1999, 12}, {1999, 1}}, ms)
+
+	// The same month written differently is only fetched once.
+	ms, err = ParseMonths("1999-01,1999-1,2001-07,1999-01")
+	r.NoError(err)
+	r.Equal([]Month{{1999, 1}, {2001, 7}}, ms)
+
+	_, err = ParseMonths("1999-13")
+	r.Error(err)
+	_, err = ParseMonths("1999")
+	r.Error(err)
+
+	r.Equal([]Month{{1999, 11}, {1999, 12}, {2000, 1}}, MonthRange(Month{1999, 11}, Month{2000, 1}))
+	r.Empty(MonthRange(Month{2000, 1}, Month{1999, 11}))
+}
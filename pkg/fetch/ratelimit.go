@@ -0,0 +1,162 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+const (
+	// NY Times API quotas, see https://developer.nytimes.com/faq
+	DefaultRequestsPerMinute = 5
+	DefaultRequestsPerDay    = 500
+)
+
+var ErrDailyQuotaExceeded = errors.New("daily NY Times API quota exceeded")
+
+// RateLimiter is a token bucket rate limiter that also enforces a daily
+// request quota. The daily quota counter is optionally persisted to a file
+// so that restarting the fetcher doesn't reset it.
+type RateLimiter struct {
+	PerMinute int
+	PerDay    int
+	Burst     int
+	QuotaFile string
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	quota  Quota
+	now    func() time.Time
+}
+
+// Quota is the number of requests used on a given (UTC) day.
+type Quota struct {
+	Day  string `json:"day"`
+	Used int    `json:"used"`
+}
+
+// NewRateLimiter returns a rate limiter allowing perMinute requests per
+// minute and perDay requests per day, loading the daily quota counter
+// from quotaFile if set.
+func NewRateLimiter(perMinute, perDay int, quotaFile string) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		PerMinute: perMinute,
+		PerDay:    perDay,
+		Burst:     1,
+		QuotaFile: quotaFile,
+		tokens:    1,
+		now:       time.Now,
+	}
+	rl.last = rl.now()
+
+	if quotaFile != "" {
+		data, err := os.ReadFile(quotaFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "could not read quota file %s", quotaFile)
+		}
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &rl.quota)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not decode quota file %s", quotaFile)
+			}
+		}
+	}
+
+	return rl, nil
+}
+
+// Wait blocks until a request may be made, then records the request
+// against the daily quota.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		rl.mu.Lock()
+
+		now := rl.now()
+		today := now.UTC().Format("2006-01-02")
+		if rl.quota.Day != today {
+			rl.quota = Quota{Day: today}
+		}
+		if rl.PerDay > 0 && rl.quota.Used >= rl.PerDay {
+			rl.mu.Unlock()
+			return errors.Wrapf(ErrDailyQuotaExceeded, "used %d of %d requests on %s", rl.quota.Used, rl.PerDay, today)
+		}
+
+		wait := rl.take(now)
+		if wait == 0 {
+			rl.quota.Used++
+			err := rl.saveQuota()
+			rl.mu.Unlock()
+			return err
+		}
+
+		rl.mu.Unlock()
+
+		err := util.Sleep(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// take refills the bucket and takes a token if one is available,
+// otherwise returns how long to wait until the next token.
+func (rl *RateLimiter) take(now time.Time) time.Duration {
+	if rl.PerMinute <= 0 {
+		return 0
+	}
+
+	burst := float64(rl.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	perToken := time.Minute / time.Duration(rl.PerMinute)
+
+	rl.tokens += float64(now.Sub(rl.last)) / float64(perToken)
+	if rl.tokens > burst {
+		rl.tokens = burst
+	}
+	rl.last = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - rl.tokens) * float64(perToken))
+}
+
+// Used returns the number of requests made today.
+func (rl *RateLimiter) Used() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.quota.Used
+}
+
+func (rl *RateLimiter) saveQuota() error {
+	if rl.QuotaFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(rl.quota)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(rl.QuotaFile, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, nil)
+}
+
+// DefaultBackoff returns the backoff used when requests to the API fail.
+func DefaultBackoff() *util.Backoff {
+	return &util.Backoff{Base: 2 * time.Second, Max: 2 * time.Minute, MaxRetries: 5}
+}
@@ -0,0 +1,46 @@
+package fetch
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := require.New(t)
+
+	quotaFile := filepath.Join(t.TempDir(), "quota.json")
+	now := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	rl, err := NewRateLimiter(5, 3, quotaFile)
+	r.NoError(err)
+	rl.now = func() time.Time { return now }
+	rl.last = now
+
+	r.Zero(rl.take(now))
+	r.Equal(12*time.Second, rl.take(now))
+	r.Equal(6*time.Second, rl.take(now.Add(6*time.Second)))
+
+	now = now.Add(time.Minute)
+	rl.tokens = 1
+	r.NoError(rl.Wait(context.Background()))
+	rl.tokens = 1
+	r.NoError(rl.Wait(context.Background()))
+	r.Equal(2, rl.Used())
+
+	// The daily quota survives a restart.
+	rl, err = NewRateLimiter(0, 3, quotaFile)
+	r.NoError(err)
+	rl.now = func() time.Time { return now }
+
+	r.NoError(rl.Wait(context.Background()))
+	r.ErrorIs(rl.Wait(context.Background()), ErrDailyQuotaExceeded)
+
+	// And resets the next day.
+	now = now.Add(24 * time.Hour)
+	r.NoError(rl.Wait(context.Background()))
+	r.Equal(1, rl.Used())
+}
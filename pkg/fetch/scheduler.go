@@ -0,0 +1,127 @@
+package fetch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Job is a unit of work that can be run with any client.
+type Job func(ctx context.Context, c *Client) error
+
+// Scheduler runs jobs concurrently, one worker per client. Typically every
+// client has its own API key and rate limiter.
+//
+// When a client runs out of daily quota its job is handed back to the queue
+// and the client is retired, the remaining clients carry on. Any other error
+// stops all workers.
+type Scheduler struct {
+	Clients []*Client
+
+	// OnRetire is called when a client is retired.
+	OnRetire func(c *Client, err error)
+
+	mu   sync.Mutex
+	jobs []Job
+}
+
+func NewScheduler(clients []*Client) *Scheduler {
+	return &Scheduler{Clients: clients}
+}
+
+// Run runs all jobs in order of the slice (although they may complete in any
+// order) and blocks until they're done. If clients are retired before all
+// jobs are done ErrDailyQuotaExceeded is returned along with the number of
+// jobs left. If ctx is cancelled its error is returned instead, and jobs
+// that were interrupted count as left.
+func (s *Scheduler) Run(ctx context.Context, jobs []Job) (remaining int, err error) {
+	if len(s.Clients) == 0 {
+		return len(jobs), errors.New("scheduler has no clients")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.jobs = append([]Job(nil), jobs...)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for _, c := range s.Clients {
+		wg.Add(1)
+
+		go func(c *Client) {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				job, ok := s.pop()
+				if !ok {
+					return
+				}
+
+				err := job(ctx, c)
+				if err == nil {
+					continue
+				}
+
+				// A job cut short by cancellation isn't done.
+				if ctx.Err() != nil {
+					s.push(job)
+					return
+				}
+
+				if errors.Is(err, ErrDailyQuotaExceeded) {
+					s.push(job)
+					if s.OnRetire != nil {
+						s.OnRetire(c, err)
+					}
+					return
+				}
+
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+		}(c)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return len(s.jobs), firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return len(s.jobs), err
+	}
+	if len(s.jobs) > 0 {
+		return len(s.jobs), errors.Wrapf(ErrDailyQuotaExceeded, "all %d API key(s) are out of quota", len(s.Clients))
+	}
+
+	return 0, nil
+}
+
+func (s *Scheduler) pop() (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) == 0 {
+		return nil, false
+	}
+
+	job := s.jobs[0]
+	s.jobs = s.jobs[1:]
+
+	return job, true
+}
+
+// push hands a job back to the front of the queue.
+func (s *Scheduler) push(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append([]Job{job}, s.jobs...)
+}
@@ -0,0 +1,118 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	r := require.New(t)
+
+	var mu sync.Mutex
+	var done []int
+	used := map[string]int{}
+	retiredA := make(chan struct{})
+
+	clients := []*Client{{Name: "a"}, {Name: "b"}}
+
+	newJobs := func(n int) []Job {
+		var jobs []Job
+		for i := 0; i < n; i++ {
+			i := i
+			jobs = append(jobs, func(ctx context.Context, c *Client) error {
+				// Client `b` waits for client `a` to run out of quota so that
+				// it can't finish all jobs on its own.
+				if c.Name == "b" {
+					select {
+					case <-retiredA:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				// Client `a` has no daily quota left.
+				if c.Name == "a" {
+					return ErrDailyQuotaExceeded
+				}
+				used[c.Name]++
+				done = append(done, i)
+				return nil
+			})
+		}
+		return jobs
+	}
+
+	s := NewScheduler(clients)
+
+	var retired []string
+	s.OnRetire = func(c *Client, err error) {
+		retired = append(retired, c.Name)
+		close(retiredA)
+	}
+
+	remaining, err := s.Run(context.Background(), newJobs(6))
+	r.NoError(err)
+	r.Zero(remaining)
+	sort.Ints(done)
+	r.Equal([]int{0, 1, 2, 3, 4, 5}, done)
+	r.Equal([]string{"a"}, retired)
+
+	// Out of quota on all clients.
+	s = NewScheduler(clients[:1])
+	s.OnRetire = nil
+
+	remaining, err = s.Run(context.Background(), newJobs(3))
+	r.ErrorIs(err, ErrDailyQuotaExceeded)
+	r.Equal(3, remaining)
+
+	// Other errors stop all workers.
+	s = NewScheduler(clients[1:])
+
+	remaining, err = s.Run(context.Background(), []Job{func(ctx context.Context, c *Client) error {
+		return errors.New("nope")
+	}})
+	r.EqualError(err, "nope")
+	r.Zero(remaining)
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var done []int
+
+	// Job 1 is cancelled while running, so it's left along with job 2, which
+	// never starts.
+	jobs := []Job{
+		func(ctx context.Context, c *Client) error {
+			done = append(done, 0)
+			return nil
+		},
+		func(ctx context.Context, c *Client) error {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		func(ctx context.Context, c *Client) error {
+			done = append(done, 2)
+			return nil
+		},
+	}
+
+	s := NewScheduler([]*Client{{Name: "a"}})
+
+	remaining, err := s.Run(ctx, jobs)
+	r.ErrorIs(err, context.Canceled)
+	r.Equal(2, remaining)
+	r.Equal([]int{0}, done)
+}
@@ -0,0 +1,341 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The Article Search API returns 10 docs per page and at most 100
+	// pages per query.
+	ArticleSearchPageSize = 10
+	ArticleSearchMaxPages = 100
+)
+
+var nonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Source is a NY Times API that articles can be fetched from, normalised
+// into domain.NYTimesArticle.
+type Source interface {
+	// FileName returns the name of the file the fetched articles are
+	// stored in, without extension. File names are unique per source and
+	// parameters.
+	FileName() string
+	// Fetch fetches all articles from the source using the given client.
+	Fetch(ctx context.Context, c *Client) ([]*domain.NYTimesArticle, error)
+}
+
+// SourceFile returns the path of the file articles from s are stored in.
+func SourceFile(dir string, s Source, f Format) string {
+	return filepath.Join(dir, s.FileName()+f.Ext())
+}
+
+// SaveArticles atomically writes articles to a gzip compressed file in dir,
+// wrapped in the same response shape as the Archive API (unless the format
+// is NDJSON) so that loader.ReadDirWithArticles can read it. Returns the
+// path of the file.
+func SaveArticles(dir string, s Source, f Format, articles []*domain.NYTimesArticle) (string, error) {
+	nyt := new(domain.NYTimesMonthlyArticles)
+	nyt.Response.Meta.Hits = len(articles)
+	nyt.Response.Docs = articles
+
+	data, err := json.Marshal(nyt)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = f.Encode(data)
+	if err != nil {
+		return "", err
+	}
+
+	file := SourceFile(dir, s, f)
+
+	return file, WriteGzipFileAtomic(file, data)
+}
+
+// ArticleSearch fetches all articles matching a query and published in a
+// given month from the Article Search API, page by page.
+//
+// The API caps results at 1,000 docs per query, see Hits for the total
+// number of matching docs.
+type ArticleSearch struct {
+	BaseURL     string
+	Query       string
+	FilterQuery string
+	Month       Month
+
+	Hits int
+}
+
+func NewArticleSearch(baseURL, query string, m Month) *ArticleSearch {
+	return &ArticleSearch{BaseURL: baseURL, Query: query, Month: m}
+}
+
+func (s *ArticleSearch) FileName() string {
+	q := slug(s.Query + " " + s.FilterQuery)
+	if q == "" {
+		q = "all"
+	}
+	return fmt.Sprintf("search-%s-%d-%d", q, s.Month.Year, s.Month.Month)
+}
+
+func (s *ArticleSearch) Fetch(ctx context.Context, c *Client) ([]*domain.NYTimesArticle, error) {
+	var articles []*domain.NYTimesArticle
+
+	begin := s.Month.Start()
+	end := s.Month.Next().Start().AddDate(0, 0, -1)
+
+	for page := 0; page < ArticleSearchMaxPages; page++ {
+		params := url.Values{}
+		params.Set("begin_date", begin.Format("20060102"))
+		params.Set("end_date", end.Format("20060102"))
+		params.Set("sort", "oldest")
+		params.Set("page", strconv.Itoa(page))
+		if s.Query != "" {
+			params.Set("q", s.Query)
+		}
+		if s.FilterQuery != "" {
+			params.Set("fq", s.FilterQuery)
+		}
+
+		data, err := c.Get(ctx, strings.TrimSuffix(s.BaseURL, "/")+"/search/v2/articlesearch.json", params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not fetch article search page %d", page)
+		}
+
+		res := new(domain.NYTimesMonthlyArticles)
+
+		err = json.Unmarshal(data, res)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode article search page %d", page)
+		}
+
+		s.Hits = res.Response.Meta.Hits
+
+		for _, a := range res.Response.Docs {
+			a.PubDate = normalisePubDate(a.PubDate)
+			articles = append(articles, a)
+		}
+
+		if len(res.Response.Docs) < ArticleSearchPageSize || len(articles) >= s.Hits {
+			break
+		}
+	}
+
+	return articles, nil
+}
+
+// TopStories fetches the articles currently on a section front from the
+// Top Stories API.
+type TopStories struct {
+	BaseURL string
+	Section string
+	Date    time.Time // Used in the file name.
+}
+
+func NewTopStories(baseURL, section string, date time.Time) *TopStories {
+	return &TopStories{BaseURL: baseURL, Section: section, Date: date}
+}
+
+func (s *TopStories) FileName() string {
+	return fmt.Sprintf("topstories-%s-%s", slug(s.Section), s.Date.UTC().Format("2006-1-2"))
+}
+
+func (s *TopStories) Fetch(ctx context.Context, c *Client) ([]*domain.NYTimesArticle, error) {
+	u := fmt.Sprintf("%s/topstories/v2/%s.json", strings.TrimSuffix(s.BaseURL, "/"), url.PathEscape(s.Section))
+
+	data, err := c.Get(ctx, u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch top stories for section %s", s.Section)
+	}
+
+	var res struct {
+		Results []struct {
+			URI           string   `json:"uri"`
+			URL           string   `json:"url"`
+			Section       string   `json:"section"`
+			Title         string   `json:"title"`
+			Abstract      string   `json:"abstract"`
+			Byline        string   `json:"byline"`
+			ItemType      string   `json:"item_type"`
+			PublishedDate string   `json:"published_date"`
+			DesFacet      []string `json:"des_facet"`
+			OrgFacet      []string `json:"org_facet"`
+			PerFacet      []string `json:"per_facet"`
+			GeoFacet      []string `json:"geo_facet"`
+			Multimedia    []struct {
+				URL    string `json:"url"`
+				Format string `json:"format"`
+				Height int64  `json:"height"`
+				Width  int64  `json:"width"`
+			} `json:"multimedia"`
+		} `json:"results"`
+	}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode top stories for section %s", s.Section)
+	}
+
+	var articles []*domain.NYTimesArticle
+
+	for _, r := range res.Results {
+		a := &domain.NYTimesArticle{
+			ID:           r.URI,
+			Abstract:     r.Abstract,
+			PubDate:      normalisePubDate(r.PublishedDate),
+			SectionName:  r.Section,
+			DocumentType: strings.ToLower(r.ItemType),
+			WebURL:       r.URL,
+			Keywords:     facetKeywords(r.DesFacet, r.OrgFacet, r.PerFacet, r.GeoFacet),
+		}
+		a.Headline.Main = r.Title
+		a.Byline.Original = r.Byline
+
+		for _, m := range r.Multimedia {
+			a.Multimedia = append(a.Multimedia, domain.Multimedia{URL: m.URL, Width: m.Width, Height: m.Height, SubType: m.Format})
+		}
+
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+// MostPopular fetches the most viewed, shared or emailed articles over the
+// last 1, 7 or 30 days from the Most Popular API.
+type MostPopular struct {
+	BaseURL string
+	Kind    string // One of `viewed`, `shared` or `emailed`.
+	Period  int    // One of 1, 7 or 30 days.
+	Date    time.Time
+}
+
+func NewMostPopular(baseURL, kind string, period int, date time.Time) *MostPopular {
+	return &MostPopular{BaseURL: baseURL, Kind: kind, Period: period, Date: date}
+}
+
+func (s *MostPopular) FileName() string {
+	return fmt.Sprintf("mostpopular-%s-%d-%s", slug(s.Kind), s.Period, s.Date.UTC().Format("2006-1-2"))
+}
+
+func (s *MostPopular) Fetch(ctx context.Context, c *Client) ([]*domain.NYTimesArticle, error) {
+	switch s.Kind {
+	case "viewed", "shared", "emailed":
+	default:
+		return nil, errors.Errorf("invalid most popular kind `%s`, expected viewed, shared or emailed", s.Kind)
+	}
+	switch s.Period {
+	case 1, 7, 30:
+	default:
+		return nil, errors.Errorf("invalid most popular period %d, expected 1, 7 or 30", s.Period)
+	}
+
+	u := fmt.Sprintf("%s/mostpopular/v2/%s/%d.json", strings.TrimSuffix(s.BaseURL, "/"), s.Kind, s.Period)
+
+	data, err := c.Get(ctx, u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch most %s", s.Kind)
+	}
+
+	var res struct {
+		Results []struct {
+			URI           string   `json:"uri"`
+			URL           string   `json:"url"`
+			Section       string   `json:"section"`
+			Title         string   `json:"title"`
+			Abstract      string   `json:"abstract"`
+			Byline        string   `json:"byline"`
+			Type          string   `json:"type"`
+			PublishedDate string   `json:"published_date"`
+			DesFacet      []string `json:"des_facet"`
+			OrgFacet      []string `json:"org_facet"`
+			PerFacet      []string `json:"per_facet"`
+			GeoFacet      []string `json:"geo_facet"`
+			Media         []struct {
+				Metadata []struct {
+					URL    string `json:"url"`
+					Format string `json:"format"`
+					Height int64  `json:"height"`
+					Width  int64  `json:"width"`
+				} `json:"media-metadata"`
+			} `json:"media"`
+		} `json:"results"`
+	}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode most %s", s.Kind)
+	}
+
+	var articles []*domain.NYTimesArticle
+
+	for _, r := range res.Results {
+		a := &domain.NYTimesArticle{
+			ID:           r.URI,
+			Abstract:     r.Abstract,
+			PubDate:      normalisePubDate(r.PublishedDate),
+			SectionName:  r.Section,
+			DocumentType: strings.ToLower(r.Type),
+			WebURL:       r.URL,
+			Keywords:     facetKeywords(r.DesFacet, r.OrgFacet, r.PerFacet, r.GeoFacet),
+		}
+		a.Headline.Main = r.Title
+		a.Byline.Original = r.Byline
+
+		for _, m := range r.Media {
+			for _, md := range m.Metadata {
+				a.Multimedia = append(a.Multimedia, domain.Multimedia{URL: md.URL, Width: md.Width, Height: md.Height, SubType: md.Format})
+			}
+		}
+
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+// facetKeywords converts Top Stories and Most Popular facets into
+// keywords, using the same keyword names as the Archive API.
+func facetKeywords(des, org, per, geo []string) []domain.Keyword {
+	var kws []domain.Keyword
+
+	add := func(name string, values []string) {
+		for _, v := range values {
+			kws = append(kws, domain.Keyword{Name: name, Rank: int64(len(kws) + 1), Value: v})
+		}
+	}
+
+	add("subject", des)
+	add("organizations", org)
+	add("persons", per)
+	add("glocations", geo)
+
+	return kws
+}
+
+// normalisePubDate converts the date formats used by the various NY Times
+// APIs to the `2006-01-02T15:04:05+0000` format used by the Archive API.
+func normalisePubDate(s string) string {
+	for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format("2006-01-02T15:04:05-0700")
+		}
+	}
+	return s
+}
+
+func slug(s string) string {
+	return strings.Trim(nonAlnum.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
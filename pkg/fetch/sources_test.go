@@ -0,0 +1,93 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSources(t *testing.T) {
+	r := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/search/v2/articlesearch.json":
+			r.Equal("20230201", req.URL.Query().Get("begin_date"))
+			r.Equal("20230228", req.URL.Query().Get("end_date"))
+			r.Equal("climate", req.URL.Query().Get("q"))
+
+			// 12 hits over 2 pages.
+			page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+			var docs []string
+			for i := page * 10; i < 12 && i < (page+1)*10; i++ {
+				docs = append(docs, fmt.Sprintf(`{"_id":"nyt://article/%d","pub_date":"2023-02-01T05:00:00-0500"}`, i))
+			}
+			fmt.Fprintf(w, `{"status":"OK","response":{"meta":{"hits":12},"docs":[%s]}}`, strings.Join(docs, ","))
+		case "/topstories/v2/world.json":
+			w.Write([]byte(`{"status":"OK","results":[{"uri":"nyt://article/ts","section":"world","title":"Top","item_type":"Article","published_date":"2023-02-17T05:00:42-05:00","des_facet":["Politics"],"geo_facet":["France"],"multimedia":[{"url":"https://static01.nyt.com/a.jpg","format":"Super Jumbo","height":1365,"width":2048}]}]}`))
+		case "/mostpopular/v2/viewed/7.json":
+			w.Write([]byte(`{"status":"OK","results":[{"uri":"nyt://article/mp","title":"Popular","type":"Article","published_date":"2023-02-16","per_facet":["Doe, Jane"],"media":[{"media-metadata":[{"url":"https://static01.nyt.com/b.jpg","format":"Standard Thumbnail","height":75,"width":75}]}]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(StaticKey("secret"))
+	date := time.Date(2023, 2, 17, 12, 0, 0, 0, time.UTC)
+
+	as := NewArticleSearch(srv.URL, "climate", Month{2023, 2})
+	r.Equal("search-climate-2023-2", as.FileName())
+
+	articles, err := as.Fetch(context.Background(), c)
+	r.NoError(err)
+	r.Len(articles, 12)
+	r.Equal(12, as.Hits)
+	r.Equal("2023-02-01T10:00:00+0000", articles[0].PubDate)
+
+	ts := NewTopStories(srv.URL, "world", date)
+	r.Equal("topstories-world-2023-2-17", ts.FileName())
+
+	articles, err = ts.Fetch(context.Background(), c)
+	r.NoError(err)
+	r.Len(articles, 1)
+	r.Equal("nyt://article/ts", articles[0].ID)
+	r.Equal("Top", articles[0].Headline.Main)
+	r.Equal("2023-02-17T10:00:42+0000", articles[0].PubDate)
+	r.Equal("world", articles[0].SectionName)
+	r.Equal("article", articles[0].DocumentType)
+	r.Len(articles[0].Keywords, 2)
+	r.Equal("glocations", articles[0].Keywords[1].Name)
+	r.Equal("France", articles[0].Keywords[1].Value)
+	r.Len(articles[0].Multimedia, 1)
+
+	mp := NewMostPopular(srv.URL, "viewed", 7, date)
+	r.Equal("mostpopular-viewed-7-2023-2-17", mp.FileName())
+
+	articles, err = mp.Fetch(context.Background(), c)
+	r.NoError(err)
+	r.Len(articles, 1)
+	r.Equal("2023-02-16T00:00:00+0000", articles[0].PubDate)
+	r.Equal("persons", articles[0].Keywords[0].Name)
+	r.Equal(int64(75), articles[0].Multimedia[0].Width)
+
+	_, err = NewMostPopular(srv.URL, "liked", 7, date).Fetch(context.Background(), c)
+	r.Error(err)
+
+	dir := t.TempDir()
+	file, err := SaveArticles(dir, mp, FormatNDJSON, articles)
+	r.NoError(err)
+
+	r.Equal(dir+"/mostpopular-viewed-7-2023-2-17.ndjson.gz", file)
+
+	e, err := Describe(file)
+	r.NoError(err)
+	r.Equal(1, e.ArticleCount)
+}
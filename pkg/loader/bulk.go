@@ -0,0 +1,271 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// DefaultBulkBackoff returns the backoff used when the indexer rejects a
+// bulk.
+func DefaultBulkBackoff() *util.Backoff {
+	return &util.Backoff{Base: time.Second, Max: time.Minute, MaxRetries: 10}
+}
+
+// batch is a bulk of docs along with the position of its last article.
+type batch struct {
+	seq    int
+	docIDs []string
+	docs   []interface{}
+	last   Position
+	failed int // Number of docs written to the dead-letter file.
+}
+
+// bulkState is shared between the loader and its bulk workers.
+type bulkState struct {
+	batches chan *batch
+	wg      sync.WaitGroup
+	seq     int // Sequence number of the next batch, only used by the producer.
+
+	mu         sync.Mutex // Guards everything below.
+	err        error
+	nextAck    int
+	acked      map[int]*batch // Indexed batches waiting for earlier batches.
+	pauseUntil time.Time
+	workers    []workerStats
+}
+
+type workerStats struct {
+	docs int
+	secs float64
+}
+
+// startWorkers starts l.Workers bulk workers reading from a channel with
+// room for l.Workers batches, so that IndexArticle blocks (and decoding
+// stalls) once all workers are busy and the channel is full.
+func (l *Loader) startWorkers() {
+	l.bulk.batches = make(chan *batch, l.Workers)
+	l.bulk.workers = make([]workerStats, l.Workers)
+
+	for w := 0; w < l.Workers; w++ {
+		l.bulk.wg.Add(1)
+
+		go func(w int) {
+			defer l.bulk.wg.Done()
+
+			for b := range l.bulk.batches {
+				// Keep draining after an error so that the producer
+				// never blocks.
+				if l.bulkErr() != nil {
+					continue
+				}
+
+				err := l.index(w, b)
+				if err != nil {
+					l.setBulkErr(err)
+				}
+			}
+		}(w)
+	}
+}
+
+// wait waits for all bulk workers to finish and returns the first error
+// any of them got.
+func (l *Loader) wait() error {
+	if l.bulk.batches != nil {
+		close(l.bulk.batches)
+		l.bulk.wg.Wait()
+		l.bulk.batches = nil
+	}
+	return l.bulkErr()
+}
+
+// setBulkErr records the first error of a bulk indexed in the background.
+func (l *Loader) setBulkErr(err error) {
+	l.bulk.mu.Lock()
+	defer l.bulk.mu.Unlock()
+
+	if l.bulk.err == nil {
+		l.bulk.err = err
+	}
+}
+
+func (l *Loader) bulkErr() error {
+	l.bulk.mu.Lock()
+	defer l.bulk.mu.Unlock()
+
+	return l.bulk.err
+}
+
+// index bulk indexes a batch, backing off and retrying (pausing all workers)
+// while the indexer rejects it or some of its docs, then acknowledges it.
+// Docs that fail permanently, or still fail once retries are exhausted, are
+// written to the dead-letter file.
+func (l *Loader) index(worker int, b *batch) error {
+	ctx := l.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), cancelledFlushTimeout)
+		defer cancel()
+	}
+
+	backoff := l.Backoff
+	if backoff == nil {
+		backoff = DefaultBulkBackoff()
+	}
+
+	docIDs, docs := b.docIDs, b.docs
+
+	for attempt := 0; ; attempt++ {
+		l.bulk.mu.Lock()
+		pause := time.Until(l.bulk.pauseUntil)
+		l.bulk.mu.Unlock()
+
+		if pause > 0 {
+			err := util.Sleep(ctx, pause)
+			if err != nil {
+				return err
+			}
+		}
+
+		timer := time.Now()
+
+		res, err := l.i.BulkIndex(ctx, l.indexName, docIDs, docs)
+
+		var delay time.Duration
+
+		if err == nil {
+			l.bulk.mu.Lock()
+			if worker < len(l.bulk.workers) {
+				l.bulk.workers[worker].docs += res.Indexed
+				l.bulk.workers[worker].secs += time.Since(timer).Seconds()
+			}
+			l.bulk.mu.Unlock()
+
+			if len(res.Failures) == 0 {
+				break
+			}
+
+			var retry, failed []domain.BulkFailure
+			for _, f := range res.Failures {
+				if f.Pos < 0 || f.Pos >= len(docs) {
+					return errors.Errorf("could not bulk index: failure of doc %s at position %d is out of range", f.DocID, f.Pos)
+				}
+				if f.Retriable && attempt < backoff.MaxRetries {
+					retry = append(retry, f)
+				} else {
+					failed = append(failed, f)
+				}
+			}
+
+			err := l.writeDeadLetters(failed, docs, attempt+1)
+			if err != nil {
+				return errors.Wrap(err, "could not bulk index")
+			}
+			b.failed += len(failed)
+
+			if len(retry) == 0 {
+				break
+			}
+
+			// Only retry the docs that failed. Failures are matched to docs
+			// by position, as a bulk may contain the same ID twice.
+			var retryIDs []string
+			var retryDocs []interface{}
+			for _, f := range retry {
+				retryIDs = append(retryIDs, docIDs[f.Pos])
+				retryDocs = append(retryDocs, docs[f.Pos])
+			}
+			docIDs, docs = retryIDs, retryDocs
+
+			delay = backoff.Delay(attempt)
+
+			fmt.Printf("%d docs rejected (%s), retrying in %s (worker %d, attempt %d)\n", len(retry), retry[0].Type, delay.Round(time.Millisecond), worker+1, attempt+1)
+		} else {
+			if !errors.Is(err, domain.ErrBulkRejected) || attempt >= backoff.MaxRetries {
+				return errors.Wrap(err, "could not bulk index")
+			}
+
+			delay = backoff.Delay(attempt)
+
+			fmt.Printf("Bulk rejected (%s), backing off for %s (worker %d, attempt %d)\n", err, delay.Round(time.Millisecond), worker+1, attempt+1)
+		}
+
+		// Pause all workers, not just this one.
+		l.bulk.mu.Lock()
+		if until := time.Now().Add(delay); until.After(l.bulk.pauseUntil) {
+			l.bulk.pauseUntil = until
+		}
+		l.bulk.mu.Unlock()
+	}
+
+	return l.ack(b)
+}
+
+// ack marks a batch as indexed and, once all earlier batches are indexed
+// too, saves a checkpoint.
+func (l *Loader) ack(b *batch) error {
+	l.bulk.mu.Lock()
+	defer l.bulk.mu.Unlock()
+
+	if l.bulk.acked == nil {
+		l.bulk.acked = make(map[int]*batch)
+	}
+
+	l.bulk.acked[b.seq] = b
+
+	var last Position
+	for {
+		b, ok := l.bulk.acked[l.bulk.nextAck]
+		if !ok {
+			break
+		}
+		delete(l.bulk.acked, l.bulk.nextAck)
+		l.bulk.nextAck++
+		l.Indexed += len(b.docs) - b.failed
+		last = b.last
+	}
+
+	if l.CheckpointFile == "" || last.File == "" {
+		return nil
+	}
+
+	cp := &Checkpoint{
+		IndexName: l.indexName,
+		File:      last.File,
+		Offset:    last.Index + 1,
+		Articles:  l.Indexed,
+		UpdatedAt: time.Now(),
+	}
+
+	return errors.Wrap(cp.Save(l.CheckpointFile), "could not save checkpoint")
+}
+
+// PrintBulkIndexingRate prints the indexer's bulk indexing rate, along with
+// the rate of each worker.
+func (l *Loader) PrintBulkIndexingRate() {
+	l.i.PrintBulkIndexingRate()
+
+	l.bulk.mu.Lock()
+	defer l.bulk.mu.Unlock()
+
+	if len(l.bulk.workers) < 2 {
+		return
+	}
+
+	for w, s := range l.bulk.workers {
+		var rate float64
+		if s.secs > 0 {
+			rate = float64(s.docs) / s.secs
+		}
+		fmt.Printf("  worker %d: %.02f docs / sec  (%d docs)\n", w+1, rate, s.docs)
+	}
+}
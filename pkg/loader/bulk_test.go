@@ -0,0 +1,217 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// rejectingIndexer rejects every other bulk.
+type rejectingIndexer struct {
+	nopIndexer
+	mu       sync.Mutex
+	calls    int
+	rejected int
+	ids      []string
+}
+
+func (i *rejectingIndexer) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	i.mu.Lock()
+	i.calls++
+	if i.calls%2 == 0 {
+		i.rejected++
+		i.mu.Unlock()
+		return nil, errors.Wrap(domain.ErrBulkRejected, "429")
+	}
+	i.mu.Unlock()
+
+	// Simulate a slow search engine.
+	time.Sleep(time.Millisecond)
+
+	i.mu.Lock()
+	i.ids = append(i.ids, docIDs...)
+	i.mu.Unlock()
+
+	return &domain.BulkResult{Indexed: len(docIDs)}, nil
+}
+
+func TestLoaderWorkers(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	cpFile := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	var expected []string
+	var docs []string
+	for i := 0; i < 1_000; i++ {
+		id := fmt.Sprintf("%04d", i)
+		expected = append(expected, id)
+		docs = append(docs, fmt.Sprintf(`{"_id": %q, "pub_date": "2000-01-01T00:00:00+0000"}`, id))
+	}
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"),
+		fmt.Sprintf(`{"response": {"docs": [%s]}}`, strings.Join(docs, ",")))
+
+	i := new(rejectingIndexer)
+	ld := New("test", 10, i)
+	ld.Workers = 4
+	ld.Backoff = &util.Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond, MaxRetries: 100}
+	ld.CheckpointFile = cpFile
+
+	_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:          dir,
+		EachArticleAt: ld.IndexArticleAt,
+	})
+	r.NoError(err)
+
+	r.Positive(i.rejected)
+	r.Equal(1_000, ld.Indexed)
+	sort.Strings(i.ids)
+	r.Equal(expected, i.ids)
+
+	cp, err := LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal(1_000, cp.Offset)
+	r.Equal(1_000, cp.Articles)
+
+	// Give up after too many rejections.
+	i = new(rejectingIndexer)
+	ld = New("test", 10, i)
+	ld.Workers = 4
+	ld.Backoff = &util.Backoff{Base: time.Millisecond, Max: time.Millisecond, MaxRetries: 0}
+
+	_, err = ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:          dir,
+		EachArticleAt: ld.IndexArticleAt,
+	})
+	r.ErrorIs(err, domain.ErrBulkRejected)
+}
+
+func TestLoaderAck(t *testing.T) {
+	r := require.New(t)
+
+	cpFile := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	ld := New("test", 10, new(fakeIndexer))
+	ld.CheckpointFile = cpFile
+
+	batches := []*batch{
+		{seq: 0, docs: make([]interface{}, 2), last: Position{File: "a", Index: 1}},
+		{seq: 1, docs: make([]interface{}, 2), last: Position{File: "a", Index: 3}},
+		{seq: 2, docs: make([]interface{}, 2), last: Position{File: "b", Index: 1}},
+	}
+
+	// Batches acknowledged out of order only move the checkpoint once all
+	// earlier batches are acknowledged.
+	r.NoError(ld.ack(batches[1]))
+	cp, err := LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Nil(cp)
+
+	r.NoError(ld.ack(batches[0]))
+	cp, err = LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal("a", cp.File)
+	r.Equal(4, cp.Offset)
+	r.Equal(4, cp.Articles)
+
+	r.NoError(ld.ack(batches[2]))
+	cp, err = LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal("b", cp.File)
+	r.Equal(2, cp.Offset)
+	r.Equal(6, cp.Articles)
+}
+
+// batchIndexer records the size of every bulk, and the last doc.
+type batchIndexer struct {
+	nopIndexer
+	mu      sync.Mutex
+	batches []int
+	last    interface{}
+}
+
+func (i *batchIndexer) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.batches = append(i.batches, len(docs))
+	i.last = docs[len(docs)-1]
+
+	return &domain.BulkResult{Indexed: len(docs)}, nil
+}
+
+func (i *batchIndexer) sizes() []int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return append([]int(nil), i.batches...)
+}
+
+func TestLoaderMaxBulkBytes(t *testing.T) {
+	r := require.New(t)
+
+	i := new(batchIndexer)
+	ld := New("test", 100, i)
+	ld.MaxBulkBytes = 1_000
+
+	a := &domain.NYTimesArticle{ID: "1", PubDate: "2000-01-01T00:00:00+0000"}
+	big := &domain.NYTimesArticle{ID: "2", PubDate: "2000-01-01T00:00:00+0000", Abstract: strings.Repeat("x", 2_000)}
+
+	// Small docs are ~200 bytes each, so a bulk fits 4 of them.
+	for n := 1; n <= 10; n++ {
+		r.NoError(ld.IndexArticle(n, false, a))
+	}
+	r.Equal([]int{4, 4}, i.sizes())
+
+	// A doc bigger than the limit is sent on its own, after the docs
+	// already buffered.
+	r.NoError(ld.IndexArticle(11, false, big))
+	r.Equal([]int{4, 4, 2, 1}, i.sizes())
+
+	r.NoError(ld.IndexArticle(12, false, a))
+	r.NoError(ld.IndexArticle(12, true, nil))
+	r.Equal([]int{4, 4, 2, 1, 1}, i.sizes())
+
+	// Docs measured are sent as serialized, instead of serializing them
+	// again.
+	r.IsType(json.RawMessage{}, i.last)
+	var sa domain.SearchArticle
+	r.NoError(json.Unmarshal(i.last.(json.RawMessage), &sa))
+	r.Equal("1", sa.ID)
+}
+
+func TestLoaderFlushInterval(t *testing.T) {
+	r := require.New(t)
+
+	i := new(batchIndexer)
+	ld := New("test", 100, i)
+	ld.FlushInterval = 20 * time.Millisecond
+
+	a := &domain.NYTimesArticle{ID: "1", PubDate: "2000-01-01T00:00:00+0000"}
+
+	r.NoError(ld.IndexArticle(1, false, a))
+	r.NoError(ld.IndexArticle(2, false, a))
+	r.Empty(i.sizes())
+
+	r.Eventually(func() bool { return len(i.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	r.Equal([]int{2}, i.sizes())
+
+	r.NoError(ld.IndexArticle(3, false, a))
+	r.NoError(ld.IndexArticle(3, true, nil))
+	r.Equal([]int{2, 1}, i.sizes())
+
+	// The timer of a flushed bulk doesn't fire.
+	time.Sleep(50 * time.Millisecond)
+	r.Equal([]int{2, 1}, i.sizes())
+}
@@ -0,0 +1,62 @@
+package loader
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// Position is the position of an article in the input files.
+type Position struct {
+	File  string // Path of the file, as listed by ReadDirWithArticles.
+	Index int    // Index of the article in the file, counting all articles.
+}
+
+// Checkpoint records how far a load has come. All articles up until and
+// including the article at Offset-1 in File (and all articles in earlier
+// files) have been acknowledged by the indexer.
+type Checkpoint struct {
+	IndexName string    `json:"index_name"`
+	File      string    `json:"file"`
+	Offset    int       `json:"offset"`   // Number of articles in File acknowledged.
+	Articles  int       `json:"articles"` // Number of articles indexed in total.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LoadCheckpoint reads a checkpoint from file. Returns nil if the file
+// doesn't exist.
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "could not read checkpoint %s", file)
+	}
+
+	cp := new(Checkpoint)
+
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode checkpoint %s", file)
+	}
+
+	return cp, nil
+}
+
+// Save atomically writes the checkpoint to file.
+func (cp *Checkpoint) Save(file string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, nil)
+}
@@ -0,0 +1,201 @@
+package loader
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Input files are optionally compressed (gzip or zstd) and contain either a
+// monthly Archive API response, one article per line (NDJSON) or a tar
+// bundle of such files. Compression and tar bundles are detected by
+// extension or magic bytes, NDJSON by extension.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+// ArticleReader reads articles one at a time from a decompressed stream.
+type ArticleReader interface {
+	// Next returns the next article, or io.EOF when there are no more
+	// articles.
+	Next() (*domain.NYTimesArticle, error)
+	// Offset returns the number of bytes of the input read so far.
+	Offset() int64
+}
+
+// NDJSONDecoder decodes articles stored one per line.
+type NDJSONDecoder struct {
+	dec *json.Decoder
+}
+
+func NewNDJSONDecoder(r io.Reader) *NDJSONDecoder {
+	return &NDJSONDecoder{dec: json.NewDecoder(r)}
+}
+
+func (d *NDJSONDecoder) Next() (*domain.NYTimesArticle, error) {
+	a := new(domain.NYTimesArticle)
+
+	err := d.dec.Decode(a)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode article")
+	}
+
+	return a, nil
+}
+
+func (d *NDJSONDecoder) Offset() int64 {
+	return d.dec.InputOffset()
+}
+
+// isSupported returns true if the file name has an extension the loader
+// can read, e.g. `.json.gz`, `.ndjson.zst` or `.tar.gz`.
+func isSupported(name string) bool {
+	name = strings.ToLower(trimCompressionExt(name))
+	for _, ext := range []string{".json", ".ndjson", ".jsonl", ".tar"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBundle(name string) bool {
+	return strings.HasSuffix(strings.ToLower(trimCompressionExt(name)), ".tar")
+}
+
+func isNDJSON(name string) bool {
+	name = strings.ToLower(trimCompressionExt(name))
+	return strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".jsonl")
+}
+
+// trimCompressionExt returns the name of a file once decompressed, e.g.
+// `articles-1999-1.json.gz` becomes `articles-1999-1.json` and
+// `articles-1990s.tgz` becomes `articles-1990s.tar`.
+func trimCompressionExt(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tgz"):
+		return name[:len(name)-4] + ".tar"
+	case strings.HasSuffix(lower, ".gz"):
+		return name[:len(name)-3]
+	case strings.HasSuffix(lower, ".zst"):
+		return name[:len(name)-4]
+	}
+	return name
+}
+
+// decodeFile decodes all articles in a file, calling emit for each one.
+// Stops early if emit returns false. Returns a *FileError if the file is
+// corrupt.
+func decodeFile(file string, emit func(a *domain.NYTimesArticle) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = decodeStream(file, f, emit)
+	return err
+}
+
+// decodeStream decompresses and decodes r, where name is the name of the
+// file (or tar bundle entry) r reads. Returns false if emit stopped early.
+func decodeStream(name string, r io.Reader, emit func(a *domain.NYTimesArticle) bool) (bool, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	lower := strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") || bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return false, &FileError{File: name, Err: errors.Wrap(err, "could not read gzip header")}
+		}
+		defer gr.Close()
+
+		return decodeUncompressed(name, gr, emit)
+
+	case strings.HasSuffix(lower, ".zst") || bytes.HasPrefix(magic, zstdMagic):
+		// Files are already decoded concurrently, see decodeFiles.
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return false, &FileError{File: name, Err: errors.Wrap(err, "could not read zstd header")}
+		}
+		defer zr.Close()
+
+		return decodeUncompressed(name, zr, emit)
+	}
+
+	return decodeUncompressed(name, br, emit)
+}
+
+func decodeUncompressed(name string, r io.Reader, emit func(a *domain.NYTimesArticle) bool) (bool, error) {
+	br := bufio.NewReader(r)
+
+	header, _ := br.Peek(tarMagicOffset + len(tarMagic))
+	if isBundle(name) || (len(header) == tarMagicOffset+len(tarMagic) && bytes.HasPrefix(header[tarMagicOffset:], tarMagic)) {
+		return decodeBundle(name, br, emit)
+	}
+
+	var dec ArticleReader
+	if isNDJSON(name) {
+		dec = NewNDJSONDecoder(br)
+	} else {
+		dec = NewArticleDecoder(br)
+	}
+
+	for {
+		a, err := dec.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, &FileError{File: name, Offset: dec.Offset(), Err: err}
+		}
+
+		if !emit(a) {
+			return false, nil
+		}
+	}
+}
+
+// decodeBundle decodes all supported files in a tar bundle, in the order
+// they're stored in.
+func decodeBundle(name string, r io.Reader, emit func(a *domain.NYTimesArticle) bool) (bool, error) {
+	tr := tar.NewReader(r)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, &FileError{File: name, Err: errors.Wrap(err, "could not read tar header")}
+		}
+
+		if h.Typeflag != tar.TypeReg || !isSupported(h.Name) || isBundle(h.Name) {
+			continue
+		}
+
+		more, err := decodeStream(name+":"+h.Name, tr, emit)
+		if err != nil || !more {
+			return more, err
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package loader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFileCodecs(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	response := []byte(`{"response": {"docs": [{"_id": "1"}, {"_id": "2"}]}}`)
+	ndjson := []byte("{\"_id\": \"1\"}\n{\"_id\": \"2\"}\n")
+
+	gz := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		r.NoError(err)
+		r.NoError(w.Close())
+		return buf.Bytes()
+	}
+
+	zst := func(data []byte) []byte {
+		w, err := zstd.NewWriter(nil)
+		r.NoError(err)
+		defer w.Close()
+		return w.EncodeAll(data, nil)
+	}
+
+	tarball := func(files map[string][]byte, names ...string) []byte {
+		var buf bytes.Buffer
+		w := tar.NewWriter(&buf)
+		for _, name := range names {
+			r.NoError(w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}))
+			_, err := w.Write(files[name])
+			r.NoError(err)
+		}
+		r.NoError(w.Close())
+		return buf.Bytes()
+	}
+
+	bundle := map[string][]byte{
+		"articles-1990-1.json.gz":    gz(response),
+		"articles-1990-2.ndjson":     ndjson,
+		"README.md":                  []byte("not an article"),
+		"articles-1990-3.ndjson.zst": zst(ndjson),
+	}
+
+	for name, data := range map[string][]byte{
+		"articles-1999-1.json":        response,
+		"articles-1999-1.json.gz":     gz(response),
+		"articles-1999-1.json.zst":    zst(response),
+		"articles-1999-1.ndjson":      ndjson,
+		"articles-1999-1.jsonl":       ndjson,
+		"articles-1999-1.ndjson.gz":   gz(ndjson),
+		"articles-1999-1.ndjson.zst":  zst(ndjson),
+		"articles-1999-1.json.backup": gz(response), // Detected by magic bytes.
+		"articles-1990s.tar.gz":       gz(tarball(bundle, "articles-1990-1.json.gz", "articles-1990-2.ndjson", "README.md", "articles-1990-3.ndjson.zst")),
+		"articles-1990s.tgz":          gz(tarball(bundle, "articles-1990-1.json.gz")),
+		"articles-1990s.tar.zst":      zst(tarball(bundle, "articles-1990-2.ndjson")),
+		"articles-1990s.bundle":       tarball(bundle, "articles-1990-2.ndjson"), // Detected by magic bytes.
+	} {
+		file := filepath.Join(dir, name)
+		r.NoError(os.WriteFile(file, data, 0o644))
+
+		var ids []string
+		err := decodeFile(file, func(a *domain.NYTimesArticle) bool {
+			ids = append(ids, a.ID)
+			return true
+		})
+		r.NoError(err, name)
+
+		expected := []string{"1", "2"}
+		if name == "articles-1990s.tar.gz" {
+			expected = []string{"1", "2", "1", "2", "1", "2"}
+		}
+		r.Equal(expected, ids, name)
+	}
+
+	// Corrupt files.
+	for name, data := range map[string][]byte{
+		"articles-1999-2.json.gz":  []byte("not gzip"),
+		"articles-1999-2.json.zst": []byte("not zstd"),
+		"articles-1999-2.ndjson":   []byte("{\"_id\": \"1\"}\n{\"_id\": "),
+		"articles-1999-2.tar":      []byte("not tar"),
+	} {
+		file := filepath.Join(dir, name)
+		r.NoError(os.WriteFile(file, data, 0o644))
+
+		err := decodeFile(file, func(a *domain.NYTimesArticle) bool { return true })
+		r.ErrorIs(err, ErrCorruptFile, name)
+	}
+
+	r.True(isSupported("articles-1999-1.json.gz"))
+	r.True(isSupported("articles-1990s.tgz"))
+	r.True(isSupported("articles-1999-1.ndjson.zst"))
+	r.False(isSupported("articles-1999-1.json.backup"))
+	r.False(isSupported("notes.txt"))
+}
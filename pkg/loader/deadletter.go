@@ -0,0 +1,183 @@
+package loader
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// DeadLetter is a doc the indexer permanently rejected, as written to the
+// dead-letter file (one per line).
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Index    string          `json:"index"`
+	Status   int             `json:"status"`
+	Type     string          `json:"type"`
+	Reason   string          `json:"reason"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failed_at"`
+	Doc      json.RawMessage `json:"doc"`
+}
+
+// ReadDeadLetters reads all docs from a dead-letter file.
+func ReadDeadLetters(file string) ([]*DeadLetter, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open dead-letter file %s", file)
+	}
+	defer f.Close()
+
+	var dls []*DeadLetter
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		dl := new(DeadLetter)
+
+		err := json.Unmarshal(sc.Bytes(), dl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode line %d of dead-letter file %s", line, file)
+		}
+
+		dls = append(dls, dl)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not read dead-letter file %s", file)
+	}
+
+	return dls, nil
+}
+
+// writeDeadLetters appends the failed docs of a bulk to l.DeadLetterFile.
+// Returns an error listing the first failure if there's no dead-letter file.
+func (l *Loader) writeDeadLetters(failures []domain.BulkFailure, docs []interface{}, attempts int) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	if l.DeadLetterFile == "" {
+		f := failures[0]
+		return errors.Errorf("%d docs failed to index, e.g. doc %s: %s: %s", len(failures), f.DocID, f.Type, f.Reason)
+	}
+
+	var dls []*DeadLetter
+	now := time.Now()
+
+	for _, f := range failures {
+		doc, err := json.Marshal(docs[f.Pos])
+		if err != nil {
+			return errors.Wrapf(err, "could not marshal doc %s", f.DocID)
+		}
+
+		dls = append(dls, &DeadLetter{
+			ID:       f.DocID,
+			Index:    l.indexName,
+			Status:   f.Status,
+			Type:     f.Type,
+			Reason:   f.Reason,
+			Attempts: attempts,
+			FailedAt: now,
+			Doc:      doc,
+		})
+	}
+
+	return l.appendDeadLetters(dls)
+}
+
+// appendDeadLetters appends docs to l.DeadLetterFile.
+func (l *Loader) appendDeadLetters(dls []*DeadLetter) error {
+	var buf []byte
+
+	for _, dl := range dls {
+		line, err := json.Marshal(dl)
+		if err != nil {
+			return errors.Wrapf(err, "could not marshal dead letter for doc %s", dl.ID)
+		}
+
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+
+	// Workers may write concurrently.
+	l.deadLetterMu.Lock()
+	defer l.deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(l.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "could not open dead-letter file %s", l.DeadLetterFile)
+	}
+
+	_, err = f.Write(buf)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "could not write to dead-letter file %s", l.DeadLetterFile)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not write to dead-letter file %s", l.DeadLetterFile)
+	}
+
+	l.bulk.mu.Lock()
+	l.DeadLettered += len(dls)
+	l.bulk.mu.Unlock()
+
+	return nil
+}
+
+// Replay re-indexes all docs in a dead-letter file, in bulks of up to
+// maxBulk docs. If file is the loader's own dead-letter file it's removed
+// first, so that docs failing again (or not replayed because of an error)
+// end up in a fresh file. Returns the number of docs replayed.
+func (l *Loader) Replay(file string) (int, error) {
+	dls, err := ReadDeadLetters(file)
+	if err != nil {
+		return 0, err
+	}
+
+	if filepath.Clean(file) == filepath.Clean(l.DeadLetterFile) {
+		err := os.Remove(file)
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not remove dead-letter file %s", file)
+		}
+	}
+
+	for start := 0; start < len(dls); start += l.maxBulk {
+		end := start + l.maxBulk
+		if end > len(dls) {
+			end = len(dls)
+		}
+
+		b := &batch{seq: l.bulk.seq}
+		l.bulk.seq++
+
+		for _, dl := range dls[start:end] {
+			b.docIDs = append(b.docIDs, dl.ID)
+			b.docs = append(b.docs, dl.Doc)
+		}
+
+		err := l.index(0, b)
+		if err != nil {
+			// Don't lose the docs we didn't get to.
+			if filepath.Clean(file) == filepath.Clean(l.DeadLetterFile) {
+				if err := l.appendDeadLetters(dls[start:]); err != nil {
+					return start, err
+				}
+			}
+			return start, err
+		}
+	}
+
+	return len(dls), nil
+}
@@ -0,0 +1,158 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+// failingIndexer permanently rejects docs with IDs in bad, and rejects
+// docs with IDs in flaky (as retriable) the first time it sees them.
+type failingIndexer struct {
+	nopIndexer
+	bad   map[string]bool
+	flaky map[string]bool
+	ids   []string
+}
+
+func (i *failingIndexer) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	res := new(domain.BulkResult)
+
+	for n, id := range docIDs {
+		switch {
+		case i.bad[id]:
+			res.Failures = append(res.Failures, domain.BulkFailure{Pos: n, DocID: id, Status: 400, Type: "strict_dynamic_mapping_exception", Reason: "mapping set to strict"})
+		case i.flaky[id]:
+			delete(i.flaky, id)
+			res.Failures = append(res.Failures, domain.BulkFailure{Pos: n, DocID: id, Status: 429, Type: "es_rejected_execution_exception", Retriable: true})
+		default:
+			res.Indexed++
+			i.ids = append(i.ids, id)
+		}
+	}
+
+	return res, nil
+}
+
+func TestLoaderDeadLetters(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	dlFile := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+
+	var docs []string
+	for n := 0; n < 10; n++ {
+		docs = append(docs, fmt.Sprintf(`{"_id": "%d", "pub_date": "2000-01-01T00:00:00+0000", "abstract": "abstract %d"}`, n, n))
+	}
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"),
+		fmt.Sprintf(`{"response": {"docs": [%s]}}`, strings.Join(docs, ",")))
+
+	i := &failingIndexer{
+		bad:   map[string]bool{"2": true, "7": true},
+		flaky: map[string]bool{"3": true, "8": true},
+	}
+
+	ld := New("test", 5, i)
+	ld.Backoff = &util.Backoff{Base: time.Millisecond, Max: time.Millisecond, MaxRetries: 3}
+	ld.DeadLetterFile = dlFile
+
+	_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:          dir,
+		EachArticleAt: ld.IndexArticleAt,
+	})
+	r.NoError(err)
+
+	// Retriable failures are retried, permanent ones dead-lettered.
+	sort.Strings(i.ids)
+	r.Equal([]string{"0", "1", "3", "4", "5", "6", "8", "9"}, i.ids)
+	r.Equal(8, ld.Indexed)
+	r.Equal(2, ld.DeadLettered)
+
+	dls, err := ReadDeadLetters(dlFile)
+	r.NoError(err)
+	r.Len(dls, 2)
+	r.Equal("2", dls[0].ID)
+	r.Equal("test", dls[0].Index)
+	r.Equal(400, dls[0].Status)
+	r.Equal("strict_dynamic_mapping_exception", dls[0].Type)
+	r.Equal("mapping set to strict", dls[0].Reason)
+	r.Equal(1, dls[0].Attempts)
+	r.Contains(string(dls[0].Doc), `"abstract":"abstract 2"`)
+	r.Equal("7", dls[1].ID)
+
+	// Replaying once the mapping is fixed indexes the docs and removes the
+	// dead-letter file.
+	i = new(failingIndexer)
+	ld = New("test", 5, i)
+	ld.DeadLetterFile = dlFile
+
+	n, err := ld.Replay(dlFile)
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Equal([]string{"2", "7"}, i.ids)
+	r.NoFileExists(dlFile)
+
+	// Docs still failing end up in a fresh dead-letter file.
+	r.NoError(ld.appendDeadLetters(dls))
+
+	i = &failingIndexer{bad: map[string]bool{"7": true}}
+	ld = New("test", 5, i)
+	ld.DeadLetterFile = dlFile
+
+	_, err = ld.Replay(dlFile)
+	r.NoError(err)
+	r.Equal([]string{"2"}, i.ids)
+
+	dls, err = ReadDeadLetters(dlFile)
+	r.NoError(err)
+	r.Len(dls, 1)
+	r.Equal("7", dls[0].ID)
+
+	// Without a dead-letter file permanent failures fail the load.
+	i = &failingIndexer{bad: map[string]bool{"2": true}}
+	ld = New("test", 5, i)
+
+	_, err = ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:          dir,
+		EachArticleAt: ld.IndexArticleAt,
+	})
+	r.ErrorContains(err, "1 docs failed to index, e.g. doc 2: strict_dynamic_mapping_exception")
+}
+
+func TestLoaderDeadLettersDuplicateIDs(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	dlFile := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"), `{"response": {"docs": [
+		{"_id": "1", "pub_date": "2000-01-01T00:00:00+0000", "abstract": "first"},
+		{"_id": "1", "pub_date": "2000-01-01T00:00:00+0000", "abstract": "second"}
+	]}}`)
+
+	i := &failingIndexer{bad: map[string]bool{"1": true}}
+
+	ld := New("test", 5, i)
+	ld.DeadLetterFile = dlFile
+
+	_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:          dir,
+		EachArticleAt: ld.IndexArticleAt,
+	})
+	r.NoError(err)
+
+	// Both docs are dead-lettered, not the last one twice.
+	dls, err := ReadDeadLetters(dlFile)
+	r.NoError(err)
+	r.Len(dls, 2)
+	r.Contains(string(dls[0].Doc), `"abstract":"first"`)
+	r.Contains(string(dls[1].Doc), `"abstract":"second"`)
+}
@@ -0,0 +1,209 @@
+package loader
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/pkg/errors"
+)
+
+// ArticleDecoder decodes articles one at a time from a monthly archive
+// response by walking the JSON tokens into `response.docs`, keeping memory
+// flat regardless of the size of the response.
+type ArticleDecoder struct {
+	dec   *json.Decoder
+	depth int // Number of open containers around `response.docs`.
+	state int
+}
+
+const (
+	decoderStart = iota
+	decoderInDocs
+	decoderDone
+)
+
+func NewArticleDecoder(r io.Reader) *ArticleDecoder {
+	return &ArticleDecoder{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next article, or io.EOF when there are no more articles.
+// The rest of the input is read and validated before io.EOF is returned.
+func (d *ArticleDecoder) Next() (*domain.NYTimesArticle, error) {
+	if d.state == decoderStart {
+		found, err := d.seekDocs()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			d.state = decoderDone
+			return nil, io.EOF
+		}
+		d.state = decoderInDocs
+	}
+
+	if d.state == decoderDone {
+		return nil, io.EOF
+	}
+
+	if d.dec.More() {
+		a := new(domain.NYTimesArticle)
+
+		err := d.dec.Decode(a)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decode article")
+		}
+
+		return a, nil
+	}
+
+	// Consume the closing `]` of `docs` and the rest of the input.
+	d.state = decoderDone
+
+	_, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.skipRest()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// Offset returns the number of bytes of the input read so far.
+func (d *ArticleDecoder) Offset() int64 {
+	return d.dec.InputOffset()
+}
+
+// seekDocs positions the decoder at the first element of `response.docs`.
+// Returns false if there's no `response.docs` array in the input.
+func (d *ArticleDecoder) seekDocs() (bool, error) {
+	err := d.expectDelim('{')
+	if err != nil {
+		return false, err
+	}
+	d.depth = 1
+
+	for d.dec.More() {
+		key, err := d.key()
+		if err != nil {
+			return false, err
+		}
+
+		if key != "response" {
+			err = d.skipValue()
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		err = d.expectDelim('{')
+		if err != nil {
+			return false, err
+		}
+		d.depth = 2
+
+		for d.dec.More() {
+			key, err := d.key()
+			if err != nil {
+				return false, err
+			}
+
+			if key == "docs" {
+				err = d.expectDelim('[')
+				return err == nil, err
+			}
+
+			err = d.skipValue()
+			if err != nil {
+				return false, err
+			}
+		}
+
+		return false, d.skipRest()
+	}
+
+	return false, d.skipRest()
+}
+
+// skipRest reads the remaining input up until all open objects are closed.
+func (d *ArticleDecoder) skipRest() error {
+	for d.depth > 0 {
+		t, err := d.dec.Token()
+		if err != nil {
+			return errors.Wrap(err, "unexpected end of input")
+		}
+		if delim, ok := t.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				d.depth++
+			case '}', ']':
+				d.depth--
+			}
+		}
+	}
+
+	// Anything but EOF after the top level object is an error.
+	_, err := d.dec.Token()
+	if err != io.EOF {
+		return errors.New("unexpected data after end of response")
+	}
+
+	return nil
+}
+
+// skipValue skips the next value, without decoding it into memory.
+func (d *ArticleDecoder) skipValue() error {
+	var depth int
+
+	for {
+		t, err := d.dec.Token()
+		if err != nil {
+			return errors.Wrap(err, "could not skip value")
+		}
+
+		if delim, ok := t.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (d *ArticleDecoder) key() (string, error) {
+	t, err := d.dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := t.(string)
+	if !ok {
+		return "", errors.Errorf("expected object key, got %v", t)
+	}
+
+	return key, nil
+}
+
+func (d *ArticleDecoder) expectDelim(expected json.Delim) error {
+	t, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if delim, ok := t.(json.Delim); !ok || delim != expected {
+		return errors.Errorf("expected `%s`, got %v", expected, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package loader
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleDecoder(t *testing.T) {
+	r := require.New(t)
+
+	readAll := func(input string) ([]string, error) {
+		var ids []string
+		dec := NewArticleDecoder(strings.NewReader(input))
+		for {
+			a, err := dec.Next()
+			if err == io.EOF {
+				return ids, nil
+			}
+			if err != nil {
+				return ids, err
+			}
+			ids = append(ids, a.ID)
+		}
+	}
+
+	ids, err := readAll(`{
+		"status": "OK",
+		"copyright": "Copyright (c) 2023 The New York Times Company. All Rights Reserved.",
+		"response": {
+			"meta": {"hits": 2, "nested": [{"a": [1, 2]}, {}]},
+			"docs": [
+				{"_id": "1", "headline": {"main": "One"}, "keywords": [{"name": "subject", "rank": 1, "value": "a"}]},
+				{"_id": "2", "headline": {"main": "Two"}}
+			],
+			"after": {"x": [1]}
+		},
+		"trailing": [true, null]
+	}`)
+	r.NoError(err)
+	r.Equal([]string{"1", "2"}, ids)
+
+	ids, err = readAll(`{"response": {"meta": {"hits": 0}}}`)
+	r.NoError(err)
+	r.Empty(ids)
+
+	ids, err = readAll(`{"response": {"docs": []}}`)
+	r.NoError(err)
+	r.Empty(ids)
+
+	// Truncated input.
+	ids, err = readAll(`{"response": {"docs": [{"_id": "1"}, {"_id": "2"}, {"_id": `)
+	r.Error(err)
+	r.Equal([]string{"1", "2"}, ids)
+
+	_, err = readAll(`{"response": {"docs": [{"_id": "1"}]}`)
+	r.Error(err)
+
+	_, err = readAll(`[]`)
+	r.Error(err)
+}
@@ -1,14 +1,9 @@
 package loader
 
 import (
-	"compress/gzip"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
@@ -17,132 +12,222 @@ import (
 )
 
 type ReadDirWithArticlesParams struct {
-	Path        string
-	Suffix      string
+	// If Ctx is cancelled reading stops, EachArticle is called one final
+	// time with isLast set and Ctx.Err() is returned.
+	Ctx context.Context
+
+	Path        string   // Dir, file or glob, e.g. `data/articles-199*-*.json.gz`.
+	Suffix      string   // If empty all supported files are read, see isSupported.
+	Exclude     []string // Files never to read, e.g. the loader's own dead-letter file.
 	Verbose     bool
 	StartFrom   string
-	Max         int // If max > 0: Read max this many articles.
+	Max         int  // If max > 0: Read max this many articles.
+	Workers     int  // Number of files to decode concurrently (defaults to 1).
+	Unordered   bool // Deliver articles as soon as they're decoded, ignoring file order.
 	EachArticle func(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error
+
+	// If set EachArticleAt is called instead of EachArticle, along with the
+	// position of the article (the position is zero for the last call).
+	EachArticleAt func(pos Position, articlesTotal int, isLast bool, a *domain.NYTimesArticle) error
+
+	// If set reading starts right after the last article acknowledged in
+	// the checkpoint. Requires ordered delivery.
+	Resume *Checkpoint
+
+	// If ContinueOnError is set corrupt files and invalid articles are
+	// skipped and reported in the returned summary instead of stopping.
+	ContinueOnError bool
+
+	// If set only files and articles published within [From, To) are read.
+	From time.Time
+	To   time.Time
+
+	// If set only articles matching Filter are passed to EachArticle.
+	Filter Filter
 }
 
-func ReadDirWithArticles(p ReadDirWithArticlesParams) error {
-	var filesTotal int
-	var articlesTotal int
+// ReadDirWithArticles reads all articles from the files in a directory
+// (walked recursively), a single file or a glob, in the order of the dates
+// in their names, calling p.EachArticle for each one. Returns a *FileError
+// for corrupt files and an *ArticleError for invalid articles, unless
+// p.ContinueOnError is set.
+func ReadDirWithArticles(p ReadDirWithArticlesParams) (*ReadSummary, error) {
+	sum := new(ReadSummary)
 	var startFromFileFound bool
+	var resumeFileFound bool
 	timer := time.Now()
 
 	if p.Verbose {
-		fmt.Printf("Reading dir: %s\n", p.Path)
+		fmt.Printf("Reading: %s\n", p.Path)
 	}
 
-	des, err := os.ReadDir(p.Path)
-	if err != nil {
-		log.Fatal(err)
+	if p.Resume != nil && p.Unordered {
+		return sum, errors.New("can't resume from a checkpoint when reading unordered")
 	}
 
-	var files []string
-
-	for _, de := range des {
-		if !strings.HasSuffix(de.Name(), p.Suffix) {
-			if p.Verbose {
-				fmt.Printf("Skipping file: %s\n", de.Name())
-			}
-			continue
-		} else if de.IsDir() {
-			if p.Verbose {
-				fmt.Printf("Skipping dir: %s\n", de.Name())
-			}
-			continue
+	each := p.EachArticleAt
+	if each == nil {
+		each = func(pos Position, articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+			return p.EachArticle(articlesTotal, isLast, a)
 		}
+	}
 
-		files = append(files, de.Name())
+	files, unknown, err := listFiles(p.Path, p.Suffix)
+	if err != nil {
+		return sum, err
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		if len(files[i]) > 22 && len(files[j]) > 22 {
-			// Special case for files named `articles-YYYY-M.json.gz`
-			// where YYYY is a year and M is a 1 or 2 digit month.
-			iYear := files[i][9:13]
-			jYear := files[j][9:13]
-			if iYear == jYear {
-				if len(files[i]) == len(files[j]) {
-					return files[i] < files[j]
-				}
-				return len(files[i]) < len(files[j])
-			}
+	files, unknown = excludeFiles(files, unknown, p.Exclude)
+
+	for _, f := range unknown {
+		if p.Verbose {
+			fmt.Printf("Skipping file: %s (no date in file name)\n", f)
 		}
-		return files[i] < files[j]
-	})
+		sum.UnknownFiles = append(sum.UnknownFiles, f)
+	}
+
+	var selected []string
 
 	for _, f := range files {
 		if p.StartFrom != "" && !startFromFileFound {
-			if !strings.Contains(f, p.StartFrom) {
+			if !strings.Contains(f.path, p.StartFrom) {
 				if p.Verbose {
-					fmt.Printf("Skipping file: %s (starting from `%s`)\n", f, p.StartFrom)
+					fmt.Printf("Skipping file: %s (starting from `%s`)\n", f.path, p.StartFrom)
 				}
 				continue
 			}
 			startFromFileFound = true
 		}
 
-		if p.Verbose {
-			fmt.Printf("Reading file: %s\n", f)
+		if p.Resume != nil && !resumeFileFound {
+			if f.path != p.Resume.File {
+				if p.Verbose {
+					fmt.Printf("Skipping file: %s (resuming from `%s`)\n", f.path, p.Resume.File)
+				}
+				continue
+			}
+			resumeFileFound = true
 		}
 
-		f, err := os.Open(filepath.Join(p.Path, f))
-		if err != nil {
-			log.Fatal(err)
+		// Files contain articles for (at least) the whole month, undated
+		// bundles may contain any month.
+		month := time.Date(f.date.Year(), f.date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if !f.date.IsZero() && !overlaps(month, month.AddDate(0, 1, 0), p.From, p.To) {
+			if p.Verbose {
+				fmt.Printf("Skipping file: %s (out of range)\n", f.path)
+			}
+			continue
 		}
 
-		r, err := gzip.NewReader(f)
-		if err != nil {
-			log.Fatal(err)
-		}
+		selected = append(selected, f.path)
+	}
 
-		data, err := io.ReadAll(r)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if p.Resume != nil && !resumeFileFound {
+		return sum, errors.Errorf("could not find checkpoint file %s in %s", p.Resume.File, p.Path)
+	}
 
-		nyt := new(domain.NYTimesMonthlyArticles)
+	// Files are decoded concurrently by p.Workers workers and (unless
+	// p.Unordered is set) their articles delivered in file order.
+	done := make(chan struct{})
+	defer close(done)
 
-		err = json.Unmarshal(data, nyt)
-		if err != nil {
-			log.Fatal(err)
-		}
+	ctx := p.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-		filesTotal++
+	items := decodeFiles(selected, p.Workers, !p.Unordered, done)
 
-		for _, a := range nyt.Response.Docs {
-			articlesTotal++
+loop:
+	for ctx.Err() == nil {
+		var it item
+		var ok bool
 
-			// if p.Verbose && i == 0 {
-			// 	util.Dump(a)
-			// }
+		select {
+		case <-ctx.Done():
+			break loop
+		case it, ok = <-items:
+			if !ok {
+				break loop
+			}
+		}
 
-			if !strings.HasSuffix(a.PubDate, "+0000") {
-				log.Fatalf("found unexpected data format `%s`", a.PubDate)
+		if it.err != nil {
+			// Files that can't be opened aren't skipped, only corrupt ones.
+			if !p.ContinueOnError || !errors.Is(it.err, ErrCorruptFile) {
+				return sum, it.err
+			}
+			if p.Verbose {
+				fmt.Printf("Skipping rest of file: %v\n", it.err)
 			}
+			sum.skip(it.err)
+			continue
+		}
 
-			err = p.EachArticle(articlesTotal, false, a)
-			if err != nil {
-				return errors.Wrap(err, "got error when calling EachArticle function")
+		if it.eof {
+			sum.Files++
+			if p.Verbose {
+				fmt.Printf("Read file: %s\n", filepath.Base(it.file))
 			}
+			continue
 		}
 
-		r.Close()
-		f.Close()
+		if p.Resume != nil && it.file == p.Resume.File && it.index < p.Resume.Offset {
+			// Already indexed.
+			continue
+		}
 
-		if p.Max > 0 && articlesTotal >= p.Max {
-			fmt.Printf("Read more than max articles (max: %d), exiting early!\n", p.Max)
+		pubDate, err := time.Parse(PubDateLayout, it.a.PubDate)
+		if err != nil || !strings.HasSuffix(it.a.PubDate, "+0000") {
+			err := &ArticleError{
+				File:      it.file,
+				ArticleID: it.a.ID,
+				Err:       errors.Wrapf(ErrUnexpectedDateFormat, "got pub_date `%s`", it.a.PubDate),
+			}
+			if !p.ContinueOnError {
+				return sum, err
+			}
+			if p.Verbose {
+				fmt.Printf("Skipping article: %v\n", err)
+			}
+			sum.skip(err)
+			continue
+		}
+
+		if !inRange(pubDate, p.From, p.To) || (p.Filter != nil && !p.Filter(it.a)) {
+			sum.Filtered++
+			continue
+		}
+
+		sum.Articles++
+
+		err = each(Position{File: it.file, Index: it.index}, sum.Articles, false, it.a)
+		if err != nil {
+			return sum, errors.Wrap(err, "got error when calling EachArticle function")
+		}
+
+		if p.Max > 0 && sum.Articles >= p.Max {
+			fmt.Printf("Read max articles (max: %d), exiting early!\n", p.Max)
 			break
 		}
 	}
 
 	// Make one final call passing `isLast: true` to allow indexers to
-	// flush their buffers (if they use them).
-	p.EachArticle(articlesTotal, true, nil)
+	// flush their buffers (if they use them), also when cancelled.
+	err = each(Position{}, sum.Articles, true, nil)
+	if err != nil {
+		return sum, errors.Wrap(err, "got error when calling EachArticle function")
+	}
+
+	if ctx.Err() != nil {
+		fmt.Printf("Cancelled. Read %d files and %d articles in %s\n", sum.Files, sum.Articles, time.Since(timer))
+		return sum, ctx.Err()
+	}
+
+	fmt.Printf("Done. Read %d files in %s\n", sum.Files, time.Since(timer))
+	if len(sum.Errors) > 0 {
+		fmt.Printf("Skipped %d corrupt files and %d invalid articles\n", sum.SkippedFiles, sum.SkippedArticles)
+	}
 
-	fmt.Printf("Done. Read %d files in %s\n", filesTotal, time.Since(timer))
-	return nil
+	return sum, nil
 }
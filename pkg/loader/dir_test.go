@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func writeGzipFile(t *testing.T, file, content string) {
+	t.Helper()
+
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	w := gzip.NewWriter(f)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+}
+
+func TestReadDirWithArticlesErrors(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"),
+		`{"response": {"docs": [{"_id": "1", "pub_date": "2000-01-01T00:00:00+0000"}, {"_id": "2", "pub_date": "2000-01-01"}]}}`)
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-2.json.gz"),
+		`{"response": {"docs": [{"_id": "3", "pub_date": "2000-02-01T00:00:00+0000"}, {"_id": `)
+	r.NoError(os.WriteFile(filepath.Join(dir, "articles-2000-3.json.gz"), []byte("not gzip"), 0o644))
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-4.json.gz"),
+		`{"response": {"docs": [{"_id": "4", "pub_date": "2000-04-01T00:00:00+0000"}]}}`)
+
+	read := func(continueOnError bool) ([]string, *ReadSummary, error) {
+		var ids []string
+		sum, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+			Path:            dir,
+			Suffix:          ".json.gz",
+			ContinueOnError: continueOnError,
+			EachArticle: func(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+				if !isLast {
+					ids = append(ids, a.ID)
+				}
+				return nil
+			},
+		})
+		return ids, sum, err
+	}
+
+	ids, _, err := read(false)
+	r.ErrorIs(err, ErrUnexpectedDateFormat)
+	var ae *ArticleError
+	r.True(errors.As(err, &ae))
+	r.Equal("2", ae.ArticleID)
+	r.Equal([]string{"1"}, ids)
+
+	ids, sum, err := read(true)
+	r.NoError(err)
+	r.Equal([]string{"1", "3", "4"}, ids)
+	r.Equal(2, sum.Files)
+	r.Equal(3, sum.Articles)
+	r.Equal(2, sum.SkippedFiles)
+	r.Equal(1, sum.SkippedArticles)
+	r.Len(sum.Errors, 3)
+
+	var fe *FileError
+	r.True(errors.As(sum.Errors[1], &fe))
+	r.Equal(filepath.Join(dir, "articles-2000-2.json.gz"), fe.File)
+	r.Greater(fe.Offset, int64(0))
+	r.ErrorIs(sum.Errors[2], ErrCorruptFile)
+
+	_, err = ReadDirWithArticles(ReadDirWithArticlesParams{Path: filepath.Join(dir, "missing")})
+	r.ErrorIs(err, os.ErrNotExist)
+}
@@ -0,0 +1,73 @@
+package loader
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCorruptFile          = errors.New("corrupt file")
+	ErrUnexpectedDateFormat = errors.New("unexpected date format")
+)
+
+// FileError is returned when a file can't be decompressed or decoded.
+// Offset is the position in the uncompressed input at which decoding
+// failed.
+type FileError struct {
+	File   string
+	Offset int64
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("corrupt file %s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+// Is makes errors.Is(err, ErrCorruptFile) work for file errors.
+func (e *FileError) Is(target error) bool {
+	return target == ErrCorruptFile
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ArticleError is returned when an article fails validation.
+type ArticleError struct {
+	File      string
+	ArticleID string
+	Err       error
+}
+
+func (e *ArticleError) Error() string {
+	return fmt.Sprintf("article %s in %s: %v", e.ArticleID, e.File, e.Err)
+}
+
+func (e *ArticleError) Unwrap() error {
+	return e.Err
+}
+
+// ReadSummary summarises a ReadDirWithArticles run.
+type ReadSummary struct {
+	Files           int // Number of files read completely.
+	Articles        int // Number of articles passed to EachArticle.
+	Filtered        int // Number of articles out of range or not matching the filter.
+	SkippedFiles    int
+	SkippedArticles int
+	// UnknownFiles contains files that were skipped because their names
+	// don't contain a date.
+	UnknownFiles []string
+	// Errors contains all errors that caused files or articles to be
+	// skipped when ContinueOnError is set.
+	Errors []error
+}
+
+func (s *ReadSummary) skip(err error) {
+	if errors.Is(err, ErrCorruptFile) {
+		s.SkippedFiles++
+	} else {
+		s.SkippedArticles++
+	}
+	s.Errors = append(s.Errors, err)
+}
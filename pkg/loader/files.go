@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dataFile is an input file along with the date parsed from its name.
+type dataFile struct {
+	path string
+	date time.Time // Start of the month (or day) the file contains articles for, zero for undated bundles.
+}
+
+// Matches the date at the end of file names like `articles-YYYY-M.json.gz`
+// and `topstories-SECTION-YYYY-M-D.json.gz`.
+var fileDateRe = regexp.MustCompile(`(\d{4})-(\d{1,2})(?:-(\d{1,2}))?\.[^-]+$`)
+
+// parseFileDate returns the date of the articles in a file given its name,
+// or false if the name doesn't contain a valid date.
+func parseFileDate(name string) (time.Time, bool) {
+	m := fileDateRe.FindStringSubmatch(filepath.Base(name))
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	year, _ := strconv.Atoi(m[1])
+	month, _ := strconv.Atoi(m[2])
+	day := 1
+	if m[3] != "" {
+		day, _ = strconv.Atoi(m[3])
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || t.Month() != time.Month(month) || t.Day() != day {
+		// Out of range month or day, e.g. `1999-13`.
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// listFiles returns all files ending in suffix (or all supported files if
+// suffix is empty), given either a dir (which is walked recursively, e.g.
+// `data/YYYY/articles-YYYY-M.json.gz`), a single file or a glob such as
+// `data/articles-199*-*.json.gz`. Files are sorted by the date in their
+// name. Files whose names don't contain a date are returned separately,
+// except for tar bundles, which are sorted by name ahead of dated files.
+func listFiles(path, suffix string) (files []dataFile, unknown []string, err error) {
+	var paths []string
+
+	if strings.ContainsAny(path, "*?[") {
+		paths, err = filepath.Glob(path)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid glob `%s`", path)
+		}
+	} else {
+		err = filepath.WalkDir(path, func(p string, de fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Skip hidden files and dirs, e.g. temp files written by the
+			// fetcher.
+			if p != path && strings.HasPrefix(de.Name(), ".") {
+				if de.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !de.IsDir() {
+				paths = append(paths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not read %s", path)
+		}
+	}
+
+	for _, p := range paths {
+		if (suffix != "" && !strings.HasSuffix(p, suffix)) || (suffix == "" && !isSupported(p)) {
+			continue
+		}
+		if st, err := os.Stat(p); err != nil || st.IsDir() {
+			continue
+		}
+
+		date, ok := parseFileDate(p)
+		if !ok && !isBundle(p) {
+			unknown = append(unknown, p)
+			continue
+		}
+
+		files = append(files, dataFile{path: p, date: date})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].date.Equal(files[j].date) {
+			return files[i].date.Before(files[j].date)
+		}
+		return files[i].path < files[j].path
+	})
+
+	return files, unknown, nil
+}
+
+// excludeFiles removes the given files from files and unknown.
+func excludeFiles(files []dataFile, unknown []string, exclude []string) ([]dataFile, []string) {
+	if len(exclude) == 0 {
+		return files, unknown
+	}
+
+	excluded := make(map[string]bool)
+	for _, e := range exclude {
+		if e != "" {
+			excluded[absPath(e)] = true
+		}
+	}
+
+	var fs []dataFile
+	for _, f := range files {
+		if !excluded[absPath(f.path)] {
+			fs = append(fs, f)
+		}
+	}
+
+	var us []string
+	for _, u := range unknown {
+		if !excluded[absPath(u)] {
+			us = append(us, u)
+		}
+	}
+
+	return fs, us
+}
+
+func absPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return abs
+}
+
+// overlaps returns true if [start, end) overlaps [from, to). Zero bounds
+// are ignored.
+func overlaps(start, end, from, to time.Time) bool {
+	return (from.IsZero() || end.After(from)) && (to.IsZero() || start.Before(to))
+}
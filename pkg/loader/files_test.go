@@ -0,0 +1,121 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFileDate(t *testing.T) {
+	r := require.New(t)
+
+	for name, expected := range map[string]string{
+		"articles-1999-1.json.gz":                  "1999-01-01",
+		"data/1999/articles-1999-1.json.gz":        "1999-01-01",
+		"articles-2022-12.ndjson.gz":               "2022-12-01",
+		"search-election-2020-2020-11.json.gz":     "2020-11-01",
+		"topstories-world-2023-1-15.json.gz":       "2023-01-15",
+		"mostpopular-viewed-7-2023-2-28.ndjson.gz": "2023-02-28",
+	} {
+		d, ok := parseFileDate(name)
+		r.True(ok, name)
+		r.Equal(expected, d.Format("2006-01-02"), name)
+	}
+
+	for _, name := range []string{
+		"articles.json.gz",
+		"articles-1999-13.json.gz",
+		"topstories-world-2023-2-30.json.gz",
+		"articles-1999-1-backup.json.gz",
+	} {
+		_, ok := parseFileDate(name)
+		r.False(ok, name)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	for _, name := range []string{
+		"1999/articles-1999-10.json.gz",
+		"1999/articles-1999-2.json.gz",
+		"2000/articles-2000-1.json.gz",
+		"2000/notes.txt",
+		"2000/.articles-2000-2.json.gz.tmp-123",
+		".cache/articles-2000-3.json.gz",
+		"articles-1852-9.json.gz",
+		"search-all-1999-2.json.gz",
+		"backup.json.gz",
+		"articles-1990s.tar.gz",
+		"articles-2000-4.ndjson.zst",
+	} {
+		file := filepath.Join(dir, name)
+		r.NoError(os.MkdirAll(filepath.Dir(file), 0o755))
+		r.NoError(os.WriteFile(file, nil, 0o644))
+	}
+
+	paths := func(files []dataFile) []string {
+		var ps []string
+		for _, f := range files {
+			rel, err := filepath.Rel(dir, f.path)
+			r.NoError(err)
+			ps = append(ps, rel)
+		}
+		return ps
+	}
+
+	files, unknown, err := listFiles(dir, ".json.gz")
+	r.NoError(err)
+	r.Equal([]string{
+		"articles-1852-9.json.gz",
+		"1999/articles-1999-2.json.gz",
+		"search-all-1999-2.json.gz",
+		"1999/articles-1999-10.json.gz",
+		"2000/articles-2000-1.json.gz",
+	}, paths(files))
+	r.Equal([]string{filepath.Join(dir, "backup.json.gz")}, unknown)
+
+	// All supported files.
+	files, unknown, err = listFiles(dir, "")
+	r.NoError(err)
+	r.Equal([]string{
+		"articles-1990s.tar.gz",
+		"articles-1852-9.json.gz",
+		"1999/articles-1999-2.json.gz",
+		"search-all-1999-2.json.gz",
+		"1999/articles-1999-10.json.gz",
+		"2000/articles-2000-1.json.gz",
+		"articles-2000-4.ndjson.zst",
+	}, paths(files))
+	r.Equal([]string{filepath.Join(dir, "backup.json.gz")}, unknown)
+
+	// Excluded files are dropped whether they have a date or not.
+	files, unknown = excludeFiles(files, unknown, []string{
+		filepath.Join(dir, "backup.json.gz"),
+		filepath.Join(dir, "2000", "..", "articles-2000-4.ndjson.zst"),
+	})
+	r.Equal([]string{
+		"articles-1990s.tar.gz",
+		"articles-1852-9.json.gz",
+		"1999/articles-1999-2.json.gz",
+		"search-all-1999-2.json.gz",
+		"1999/articles-1999-10.json.gz",
+		"2000/articles-2000-1.json.gz",
+	}, paths(files))
+	r.Empty(unknown)
+
+	files, _, err = listFiles(filepath.Join(dir, "*", "articles-199*-*.json.gz"), ".json.gz")
+	r.NoError(err)
+	r.Equal([]string{"1999/articles-1999-2.json.gz", "1999/articles-1999-10.json.gz"}, paths(files))
+
+	files, _, err = listFiles(filepath.Join(dir, "2000", "articles-2000-1.json.gz"), ".json.gz")
+	r.NoError(err)
+	r.Equal([]string{"2000/articles-2000-1.json.gz"}, paths(files))
+
+	_, _, err = listFiles(filepath.Join(dir, "missing"), ".json.gz")
+	r.ErrorIs(err, os.ErrNotExist)
+}
@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"strings"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+)
+
+// PubDateLayout is the layout of `pub_date` in Archive API responses.
+const PubDateLayout = "2006-01-02T15:04:05-0700"
+
+// Filter returns true for articles that should be passed on to EachArticle.
+type Filter func(a *domain.NYTimesArticle) bool
+
+// All returns a filter matching articles that match all given filters.
+func All(filters ...Filter) Filter {
+	return func(a *domain.NYTimesArticle) bool {
+		for _, f := range filters {
+			if !f(a) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// BySection matches articles in any of the given sections, e.g. `World`.
+func BySection(sections ...string) Filter {
+	return func(a *domain.NYTimesArticle) bool {
+		return equalsAny(a.SectionName, sections)
+	}
+}
+
+// ByDocumentType matches articles of any of the given document types, e.g.
+// `article` or `multimedia`.
+func ByDocumentType(types ...string) Filter {
+	return func(a *domain.NYTimesArticle) bool {
+		return equalsAny(a.DocumentType, types)
+	}
+}
+
+// ByKeyword matches articles tagged with any of the given keyword values,
+// e.g. `Elections`.
+func ByKeyword(values ...string) Filter {
+	return func(a *domain.NYTimesArticle) bool {
+		for _, kw := range a.Keywords {
+			if equalsAny(kw.Value, values) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByByline matches articles whose byline contains s, e.g. `Maureen Dowd`.
+func ByByline(s string) Filter {
+	s = strings.ToLower(s)
+
+	return func(a *domain.NYTimesArticle) bool {
+		if strings.Contains(strings.ToLower(a.Byline.Original), s) {
+			return true
+		}
+		for _, p := range a.Byline.Person {
+			if strings.Contains(strings.ToLower(p.Firstname+" "+p.Lastname), s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func equalsAny(s string, values []string) bool {
+	for _, v := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange returns true if t is within [from, to). Zero bounds are ignored.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
@@ -0,0 +1,70 @@
+package loader
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	r := require.New(t)
+
+	a := &domain.NYTimesArticle{
+		SectionName:  "World",
+		DocumentType: "article",
+		Keywords:     []domain.Keyword{{Name: "subject", Value: "Elections"}},
+	}
+	a.Byline.Original = "By Maureen Dowd"
+
+	r.True(BySection("world", "U.S.")(a))
+	r.False(BySection("Sports")(a))
+	r.True(ByDocumentType("article")(a))
+	r.False(ByDocumentType("multimedia")(a))
+	r.True(ByKeyword("elections")(a))
+	r.False(ByKeyword("Baseball")(a))
+	r.True(ByByline("maureen dowd")(a))
+	r.False(ByByline("Paul Krugman")(a))
+
+	r.True(All()(a))
+	r.True(All(BySection("World"), ByKeyword("Elections"))(a))
+	r.False(All(BySection("World"), ByKeyword("Baseball"))(a))
+}
+
+func TestReadDirWithArticlesRangeAndFilter(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	writeGzipFile(t, filepath.Join(dir, "articles-1999-12.json.gz"), `{"response": {"docs": [
+		{"_id": "1", "pub_date": "1999-12-31T23:59:59+0000", "section_name": "World"}
+	]}}`)
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"), `{"response": {"docs": [
+		{"_id": "2", "pub_date": "2000-01-01T00:00:00+0000", "section_name": "World"},
+		{"_id": "3", "pub_date": "2000-01-15T00:00:00+0000", "section_name": "Sports"},
+		{"_id": "4", "pub_date": "2000-01-31T00:00:00+0000", "section_name": "World"}
+	]}}`)
+	// Out of range, never opened.
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-2.json.gz"), `not json`)
+
+	var ids []string
+	sum, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:   dir,
+		Suffix: ".json.gz",
+		From:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2000, 1, 31, 0, 0, 0, 0, time.UTC),
+		Filter: BySection("World"),
+		EachArticle: func(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+			if !isLast {
+				ids = append(ids, a.ID)
+			}
+			return nil
+		},
+	})
+	r.NoError(err)
+	r.Equal([]string{"2"}, ids)
+	r.Equal(1, sum.Files)
+	r.Equal(2, sum.Filtered)
+}
@@ -5,68 +5,162 @@ package loader
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
 )
 
-// Indexer defines methods for creating and loading indexes.
-type Indexer interface {
-	CreateIndex(ctx context.Context, mappingsJSONFile, indexName string)
-	BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{})
-	PrintBulkIndexingRate()
-}
+// Time given to flush buffered docs once the loader's context is
+// cancelled.
+const cancelledFlushTimeout = 30 * time.Second
+
+// Serialized size of a doc's `{"index":{"_id":"..."}}` bulk action line
+// without the ID, plus the newlines after the action line and the doc.
+const bulkActionOverhead = len(`{"index":{"_id":""}}`) + 2
 
 type Loader struct {
+	// Ctx is used for all bulk calls. Once it's cancelled any buffered docs
+	// are still flushed (within cancelledFlushTimeout) so that the index
+	// and checkpoint agree.
+	Ctx context.Context
+	// If set a checkpoint is saved to CheckpointFile after every bulk,
+	// see IndexArticleAt.
+	CheckpointFile string
+	// Number of articles indexed, including any indexed in previous runs
+	// when resuming from a checkpoint.
+	Indexed int
+	// Number of concurrent bulk workers. If Workers < 2 bulks are indexed
+	// synchronously.
+	Workers int
+	// Backoff used when the indexer rejects a bulk, defaults to
+	// DefaultBulkBackoff.
+	Backoff *util.Backoff
+	// If MaxBulkBytes > 0 a bulk is sent once the docs in it would exceed
+	// this many bytes when serialized (or maxBulk docs, whichever comes
+	// first). Docs are then buffered serialized, as json.RawMessage.
+	MaxBulkBytes int
+	// If FlushInterval > 0 a bulk is sent at the latest this long after
+	// its first doc was added.
+	FlushInterval time.Duration
+	// Transform enriches every doc built by NewSearchArticle, defaults to
+	// KeywordEngagement.
+	Transform Transformer
+	// If set docs the indexer rejects permanently are appended to
+	// DeadLetterFile (as NDJSON, see DeadLetter) instead of failing the
+	// load. They can be re-indexed later using Replay.
+	DeadLetterFile string
+	// Number of docs written to the dead-letter file.
+	DeadLettered int
+
 	indexName    string
 	maxBulk      int
-	i            Indexer
+	i            domain.Indexer
 	docs         []interface{}
 	docIDs       []string
 	lastHeadline string
 	lastPubDate  string
+	lastPos      Position // Position of the last article in docs.
+	bulk         bulkState
+
+	mu         sync.Mutex  // Guards docs, docIDs, docsBytes and lastPos against the flush timer.
+	docsBytes  int         // Serialized size of docs.
+	flushTimer *time.Timer // Set while docs is non-empty and FlushInterval > 0.
+
+	deadLetterMu sync.Mutex // Serializes writes to DeadLetterFile.
 }
 
-func New(indexName string, maxBulk int, i Indexer) *Loader {
+func New(indexName string, maxBulk int, i domain.Indexer) *Loader {
 	return &Loader{indexName: indexName, maxBulk: maxBulk, i: i}
 }
 
 func (l *Loader) IndexArticle(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
-	if isLast && len(l.docs) > 0 {
-		l.i.BulkIndex(context.Background(), l.indexName, l.docIDs, l.docs)
+	return l.IndexArticleAt(Position{}, articlesTotal, isLast, a)
+}
+
+// IndexArticleAt is like IndexArticle but also records the position of the
+// article, so that a checkpoint can be saved once it's been indexed.
+func (l *Loader) IndexArticleAt(pos Position, articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Errors from bulks flushed by the flush timer or by workers.
+	err := l.bulkErr()
+	if err != nil {
+		return err
+	}
+
+	if isLast {
+		if len(l.docs) > 0 {
+			err := l.flush()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := l.wait()
+		if err != nil {
+			return err
+		}
 
 		fmt.Printf("Indexed %d articles total\n", articlesTotal)
 		return nil
 	}
 
-	sa := &domain.SearchArticle{
-		ID:            a.ID,
-		Abstract:      a.Abstract,
-		Headline:      a.Headline.Main,
-		PrintHeadline: a.Headline.PrintHeadline,
-		LeadParagraph: a.LeadParagraph,
-		IsPublished:   true,
-		PubDate:       a.PubDate,
-		NumLikes:      uint(len(a.Keywords)),
-		NumComments:   uint(len(a.Keywords) / 2),
-		Multimedia:    a.Multimedia,
+	sa := NewSearchArticle(a)
+
+	t := l.Transform
+	if t == nil {
+		t = TransformerFunc(KeywordEngagement)
+	}
+
+	err = t.Transform(a, sa)
+	if err != nil {
+		return errors.Wrapf(err, "could not transform article %s", a.ID)
 	}
 
-	// Add keywords.
-	for _, kw := range a.Keywords {
-		sa.Keywords = append(sa.Keywords, kw.Value)
+	var doc interface{} = sa
+	var size int
+	if l.MaxBulkBytes > 0 {
+		data, err := json.Marshal(sa)
+		if err != nil {
+			return errors.Wrapf(err, "could not marshal doc %s", sa.ID)
+		}
+		// Buffer the serialized doc so that it isn't serialized again
+		// when the bulk is sent.
+		doc = json.RawMessage(data)
+		size = len(data) + len(sa.ID) + bulkActionOverhead
+
+		// Send what we have if this doc would push the bulk over the limit.
+		if len(l.docs) > 0 && l.docsBytes+size > l.MaxBulkBytes {
+			err := l.flush()
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	l.docs = append(l.docs, sa)
+	if len(l.docs) == 0 && l.FlushInterval > 0 {
+		seq := l.bulk.seq
+		l.flushTimer = time.AfterFunc(l.FlushInterval, func() { l.flushOnTimer(seq) })
+	}
+
+	l.docs = append(l.docs, doc)
 	l.docIDs = append(l.docIDs, sa.ID)
+	l.docsBytes += size
+	l.lastPos = pos
 
-	if len(l.docs) >= l.maxBulk {
+	if len(l.docs) >= l.maxBulk || (l.MaxBulkBytes > 0 && l.docsBytes >= l.MaxBulkBytes) {
 		l.lastHeadline = sa.Headline
 		l.lastPubDate = sa.PubDate
 
-		l.i.BulkIndex(context.Background(), l.indexName, l.docIDs, l.docs)
-
-		l.docs = l.docs[:0]
-		l.docIDs = l.docIDs[:0]
+		err := l.flush()
+		if err != nil {
+			return err
+		}
 	}
 
 	if articlesTotal%20_000 == 0 {
@@ -76,8 +170,57 @@ func (l *Loader) IndexArticle(articlesTotal int, isLast bool, a *domain.NYTimesA
 
 		fmt.Printf("@ article %d  --  %s [%s]\n", articlesTotal, l.lastHeadline, l.lastPubDate)
 
-		l.i.PrintBulkIndexingRate()
+		l.PrintBulkIndexingRate()
 	}
 
 	return nil
 }
+
+// flush hands all buffered docs to a bulk worker, or indexes them right
+// away if there are no workers.
+func (l *Loader) flush() error {
+	b := &batch{seq: l.bulk.seq, docIDs: l.docIDs, docs: l.docs, last: l.lastPos}
+
+	l.bulk.seq++
+	l.docs = make([]interface{}, 0, l.maxBulk)
+	l.docIDs = make([]string, 0, l.maxBulk)
+	l.docsBytes = 0
+
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+		l.flushTimer = nil
+	}
+
+	if l.Workers < 2 {
+		return l.index(0, b)
+	}
+
+	if l.bulk.batches == nil {
+		l.startWorkers()
+	}
+
+	err := l.bulkErr()
+	if err != nil {
+		return err
+	}
+
+	l.bulk.batches <- b
+
+	return nil
+}
+
+// flushOnTimer flushes the docs buffered since bulk seq was started, unless
+// they've been flushed already.
+func (l *Loader) flushOnTimer(seq int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.bulk.seq != seq || len(l.docs) == 0 {
+		return
+	}
+
+	err := l.flush()
+	if err != nil {
+		l.setBulkErr(err)
+	}
+}
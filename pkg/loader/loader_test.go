@@ -0,0 +1,183 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// nopIndexer implements all of domain.Indexer except BulkIndex.
+type nopIndexer struct{}
+
+func (nopIndexer) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error {
+	return nil
+}
+
+func (nopIndexer) DeleteIndex(ctx context.Context, indexName string) error { return nil }
+
+func (nopIndexer) Refresh(ctx context.Context, indexName string) error { return nil }
+
+func (nopIndexer) Count(ctx context.Context, indexName string) (int, error) { return 0, nil }
+
+func (nopIndexer) PrintBulkIndexingRate() {}
+
+func (nopIndexer) Close() error { return nil }
+
+type fakeIndexer struct {
+	nopIndexer
+	ids []string
+}
+
+func (i *fakeIndexer) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	i.ids = append(i.ids, docIDs...)
+	return &domain.BulkResult{Indexed: len(docIDs)}, nil
+}
+
+func TestLoaderCheckpointAndResume(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	cpFile := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	var expected []string
+
+	for m := 1; m <= 3; m++ {
+		var docs []string
+		for i := 0; i < 5; i++ {
+			id := fmt.Sprintf("2000-%d-%d", m, i)
+			expected = append(expected, id)
+			docs = append(docs, fmt.Sprintf(`{"_id": %q, "pub_date": "2000-01-01T00:00:00+0000"}`, id))
+		}
+		writeGzipFile(t, filepath.Join(dir, fmt.Sprintf("articles-2000-%d.json.gz", m)),
+			fmt.Sprintf(`{"response": {"docs": [%s]}}`, strings.Join(docs, ",")))
+	}
+
+	load := func(max int, resume *Checkpoint) *fakeIndexer {
+		i := new(fakeIndexer)
+		ld := New("test", 3, i)
+		ld.CheckpointFile = cpFile
+		if resume != nil {
+			ld.Indexed = resume.Articles
+		}
+
+		_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+			Path:          dir,
+			Max:           max,
+			Workers:       2,
+			Resume:        resume,
+			EachArticleAt: ld.IndexArticleAt,
+		})
+		r.NoError(err)
+		return i
+	}
+
+	// The first run dies after 8 articles, only 6 were acknowledged by the
+	// indexer.
+	i := new(fakeIndexer)
+	ld := New("test", 3, i)
+	ld.CheckpointFile = cpFile
+	_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path: dir,
+		EachArticleAt: func(pos Position, articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+			if articlesTotal > 8 || isLast {
+				return fmt.Errorf("crash")
+			}
+			return ld.IndexArticleAt(pos, articlesTotal, isLast, a)
+		},
+	})
+	r.Error(err)
+	r.Equal(expected[:6], i.ids)
+
+	cp, err := LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal("test", cp.IndexName)
+	r.Equal(filepath.Join(dir, "articles-2000-2.json.gz"), cp.File)
+	r.Equal(1, cp.Offset)
+	r.Equal(6, cp.Articles)
+
+	// Resume, stopping after 4 more articles.
+	i = load(4, cp)
+	r.Equal(expected[6:10], i.ids)
+
+	cp, err = LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal(filepath.Join(dir, "articles-2000-2.json.gz"), cp.File)
+	r.Equal(5, cp.Offset)
+	r.Equal(10, cp.Articles)
+
+	// Resume until done.
+	i = load(0, cp)
+	r.Equal(expected[10:], i.ids)
+
+	cp, err = LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal(filepath.Join(dir, "articles-2000-3.json.gz"), cp.File)
+	r.Equal(5, cp.Offset)
+	r.Equal(15, cp.Articles)
+
+	// Nothing left.
+	i = load(0, cp)
+	r.Empty(i.ids)
+
+	cp, err = LoadCheckpoint(filepath.Join(dir, "missing.json"))
+	r.NoError(err)
+	r.Nil(cp)
+
+	_, err = ReadDirWithArticles(ReadDirWithArticlesParams{
+		Path:        dir,
+		Resume:      &Checkpoint{File: "missing.json.gz"},
+		EachArticle: func(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error { return nil },
+	})
+	r.Error(err)
+}
+
+func TestLoaderCancel(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	cpFile := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	var docs []string
+	for i := 0; i < 100; i++ {
+		docs = append(docs, fmt.Sprintf(`{"_id": "%d", "pub_date": "2000-01-01T00:00:00+0000"}`, i))
+	}
+	writeGzipFile(t, filepath.Join(dir, "articles-2000-1.json.gz"),
+		fmt.Sprintf(`{"response": {"docs": [%s]}}`, strings.Join(docs, ",")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	i := new(fakeIndexer)
+	ld := New("test", 3, i)
+	ld.Ctx = ctx
+	ld.CheckpointFile = cpFile
+
+	sum, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+		Ctx:  ctx,
+		Path: dir,
+		EachArticleAt: func(pos Position, articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+			if articlesTotal == 5 {
+				cancel()
+			}
+			return ld.IndexArticleAt(pos, articlesTotal, isLast, a)
+		},
+	})
+	r.ErrorIs(err, context.Canceled)
+	r.Equal(5, sum.Articles)
+
+	// Buffered docs are flushed on cancellation.
+	r.Len(i.ids, 5)
+	r.Equal(5, ld.Indexed)
+
+	cp, err := LoadCheckpoint(cpFile)
+	r.NoError(err)
+	r.Equal(5, cp.Offset)
+}
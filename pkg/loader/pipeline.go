@@ -0,0 +1,133 @@
+package loader
+
+import (
+	"sync"
+
+	"github.com/anrid/nytimes/pkg/domain"
+)
+
+// Number of decoded articles buffered per file while waiting for earlier
+// files to be consumed.
+const articlesBufferedPerFile = 256
+
+// item is either an article, the end of a file or an error.
+type item struct {
+	file  string
+	index int // Index of a in file.
+	a     *domain.NYTimesArticle
+	eof   bool
+	err   error
+}
+
+// decodeFiles decodes up to `workers` files concurrently and returns a
+// stream of items. If ordered is true all items of a file are delivered
+// before any items of the next file, in the order files are given,
+// otherwise items are delivered as soon as they're decoded.
+//
+// Closing done stops all workers.
+func decodeFiles(files []string, workers int, ordered bool, done <-chan struct{}) <-chan item {
+	if workers < 1 {
+		workers = 1
+	}
+
+	out := make(chan item, articlesBufferedPerFile)
+
+	send := func(ch chan<- item, it item) bool {
+		select {
+		case ch <- it:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	decode := func(file string, ch chan<- item) {
+		var index int
+		err := decodeFile(file, func(a *domain.NYTimesArticle) bool {
+			index++
+			return send(ch, item{file: file, index: index - 1, a: a})
+		})
+		if err != nil {
+			send(ch, item{file: file, err: err})
+			return
+		}
+		send(ch, item{file: file, eof: true})
+	}
+
+	if !ordered {
+		jobs := make(chan string)
+		var wg sync.WaitGroup
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for file := range jobs {
+					decode(file, out)
+				}
+			}()
+		}
+
+		go func() {
+			defer close(out)
+			defer wg.Wait()
+			defer close(jobs)
+
+			for _, file := range files {
+				select {
+				case jobs <- file:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		return out
+	}
+
+	// Every file gets its own channel. Files are decoded concurrently but
+	// their channels are drained one at a time, in order. The semaphore
+	// limits the number of files in flight.
+	perFile := make(chan chan item, workers)
+	sem := make(chan struct{}, workers)
+
+	go func() {
+		defer close(perFile)
+
+		for _, file := range files {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			ch := make(chan item, articlesBufferedPerFile)
+
+			go func(file string) {
+				defer func() { <-sem }()
+				defer close(ch)
+				decode(file, ch)
+			}(file)
+
+			select {
+			case perFile <- ch:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(out)
+
+		for ch := range perFile {
+			for it := range ch {
+				if !send(out, it) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,63 @@
+package loader
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDirWithArticlesWorkers(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	var expected []string
+
+	// Later files are smaller so that they finish decoding first.
+	for m := 1; m <= 12; m++ {
+		var docs []string
+		for i := 0; i < (13-m)*100; i++ {
+			id := fmt.Sprintf("2000-%d-%d", m, i)
+			expected = append(expected, id)
+			docs = append(docs, fmt.Sprintf(`{"_id": %q, "pub_date": "2000-01-01T00:00:00+0000"}`, id))
+		}
+
+		writeGzipFile(t, filepath.Join(dir, fmt.Sprintf("articles-2000-%d.json.gz", m)),
+			fmt.Sprintf(`{"response": {"docs": [%s]}}`, strings.Join(docs, ",")))
+	}
+
+	read := func(workers int, unordered bool, max int) []string {
+		var ids []string
+		_, err := ReadDirWithArticles(ReadDirWithArticlesParams{
+			Path:      dir,
+			Suffix:    ".json.gz",
+			Workers:   workers,
+			Unordered: unordered,
+			Max:       max,
+			EachArticle: func(articlesTotal int, isLast bool, a *domain.NYTimesArticle) error {
+				if !isLast {
+					ids = append(ids, a.ID)
+				}
+				return nil
+			},
+		})
+		r.NoError(err)
+		return ids
+	}
+
+	r.Equal(expected, read(1, false, 0))
+	r.Equal(expected, read(4, false, 0))
+	r.Equal(expected[:1234], read(4, false, 1234))
+
+	ids := read(4, true, 0)
+	r.Len(ids, len(expected))
+	sort.Strings(ids)
+	sorted := append([]string(nil), expected...)
+	sort.Strings(sorted)
+	r.Equal(sorted, ids)
+}
@@ -0,0 +1,324 @@
+package loader
+
+import (
+	"hash/fnv"
+	"html"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/pkg/errors"
+)
+
+// Transformer enriches the search doc built from an article. Transformers
+// are chained, each one receiving the doc as left by the previous one.
+type Transformer interface {
+	Transform(a *domain.NYTimesArticle, doc *domain.SearchArticle) error
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(a *domain.NYTimesArticle, doc *domain.SearchArticle) error
+
+func (f TransformerFunc) Transform(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	return f(a, doc)
+}
+
+// Chain runs transformers in order, stopping at the first error.
+type Chain []Transformer
+
+func (c Chain) Transform(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	for _, t := range c {
+		err := t.Transform(a, doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultTransformers is the chain used by the loader unless configured
+// otherwise.
+const DefaultTransformers = "keyword-engagement"
+
+// Registry holds transformers by name, so that chains can be configured
+// by name, see ParseTransformers.
+type Registry map[string]Transformer
+
+// DefaultRegistry returns a new registry with all built-in transformers
+// except `fields`, which ParseTransformers builds from its options.
+func DefaultRegistry() Registry {
+	return Registry{
+		"keyword-engagement": TransformerFunc(KeywordEngagement),
+		"engagement":         &Engagement{Likes: Exponential{Mean: 50}, Comments: Exponential{Mean: 5}},
+		"typed-keywords":     TransformerFunc(TypedKeywords),
+		"normalise":          TransformerFunc(NormaliseText),
+	}
+}
+
+// Register makes a transformer available by name, replacing any
+// transformer already registered under that name.
+func (r Registry) Register(name string, t Transformer) {
+	r[name] = t
+}
+
+// Names returns the names of all transformers in the registry, plus
+// `fields`.
+func (r Registry) Names() []string {
+	names := []string{"fields"}
+	for name := range r {
+		if name != "fields" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TransformOptions configures the transformers available to
+// ParseTransformers.
+type TransformOptions struct {
+	// Transformers available by name, defaults to DefaultRegistry().
+	Registry Registry
+	// Fields kept by the `fields` transformer, which needs at least one.
+	Fields []string
+}
+
+// ParseTransformers returns a chain of transformers given a comma
+// separated list of names, e.g. `normalise,typed-keywords`.
+func ParseTransformers(s string, opts TransformOptions) (Chain, error) {
+	reg := opts.Registry
+	if reg == nil {
+		reg = DefaultRegistry()
+	}
+
+	var c Chain
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if name == "fields" {
+			if len(opts.Fields) == 0 {
+				return nil, errors.New("transformer `fields` needs at least one field to keep")
+			}
+			c = append(c, Fields(opts.Fields))
+			continue
+		}
+
+		t, ok := reg[name]
+		if !ok {
+			return nil, errors.Errorf("unknown transformer `%s`, available: %s", name, strings.Join(reg.Names(), ", "))
+		}
+		c = append(c, t)
+	}
+	return c, nil
+}
+
+// NewSearchArticle maps an article to a search doc, before any
+// transformers are applied.
+func NewSearchArticle(a *domain.NYTimesArticle) *domain.SearchArticle {
+	sa := &domain.SearchArticle{
+		ID:            a.ID,
+		Abstract:      a.Abstract,
+		Headline:      a.Headline.Main,
+		PrintHeadline: a.Headline.PrintHeadline,
+		LeadParagraph: a.LeadParagraph,
+		IsPublished:   true,
+		PubDate:       a.PubDate,
+		Multimedia:    a.Multimedia,
+	}
+
+	for _, kw := range a.Keywords {
+		sa.Keywords = append(sa.Keywords, kw.Value)
+	}
+
+	return sa
+}
+
+// KeywordEngagement sets fake engagement metrics derived from the number
+// of keywords.
+func KeywordEngagement(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	doc.NumLikes = uint(len(a.Keywords))
+	doc.NumComments = uint(len(a.Keywords) / 2)
+	return nil
+}
+
+// TypedKeywords prefixes keywords with their type, e.g. `subject:Elections`
+// or `glocations:Tokyo (Japan)`.
+func TypedKeywords(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	doc.Keywords = doc.Keywords[:0]
+	for _, kw := range a.Keywords {
+		doc.Keywords = append(doc.Keywords, kw.Name+":"+kw.Value)
+	}
+	return nil
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// NormaliseText unescapes HTML entities and collapses whitespace in all
+// text fields.
+func NormaliseText(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	for _, s := range []*string{&doc.Headline, &doc.PrintHeadline, &doc.Abstract, &doc.LeadParagraph} {
+		*s = strings.TrimSpace(whitespace.ReplaceAllString(html.UnescapeString(*s), " "))
+	}
+	return nil
+}
+
+// Fields keeps only the given fields (by JSON name, e.g. `headline`) of a
+// search doc, clearing all others. The `id` and `pub_date` fields are
+// always kept.
+type Fields []string
+
+func (f Fields) Transform(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	keep := map[string]bool{"id": true, "pub_date": true}
+	for _, name := range f {
+		keep[name] = true
+	}
+
+	sa := &domain.SearchArticle{ID: doc.ID, PubDate: doc.PubDate}
+
+	for name := range keep {
+		switch name {
+		case "id", "pub_date":
+		case "headline":
+			sa.Headline = doc.Headline
+		case "print_headline":
+			sa.PrintHeadline = doc.PrintHeadline
+		case "abstract":
+			sa.Abstract = doc.Abstract
+		case "lead_paragraph":
+			sa.LeadParagraph = doc.LeadParagraph
+		case "keywords":
+			sa.Keywords = doc.Keywords
+		case "is_published":
+			sa.IsPublished = doc.IsPublished
+		case "num_likes":
+			sa.NumLikes = doc.NumLikes
+		case "num_comments":
+			sa.NumComments = doc.NumComments
+		case "multimedia":
+			sa.Multimedia = doc.Multimedia
+		default:
+			return errors.Errorf("unknown field `%s`", name)
+		}
+	}
+
+	*doc = *sa
+
+	return nil
+}
+
+// Engagement sets synthetic engagement metrics sampled from the given
+// distributions. Samples are seeded by article ID, so the same article
+// always gets the same metrics.
+type Engagement struct {
+	Likes    Distribution
+	Comments Distribution
+}
+
+func (e *Engagement) Transform(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+	doc.NumLikes = e.Likes.Sample(seededRand(a.ID, "likes"))
+	doc.NumComments = e.Comments.Sample(seededRand(a.ID, "comments"))
+	return nil
+}
+
+func seededRand(id, field string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte(field))
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// Distribution samples non-negative integers.
+type Distribution interface {
+	Sample(r *rand.Rand) uint
+}
+
+// Constant always returns N.
+type Constant struct{ N uint }
+
+func (d Constant) Sample(r *rand.Rand) uint { return d.N }
+
+// Uniform returns values in [Min, Max].
+type Uniform struct{ Min, Max uint }
+
+func (d Uniform) Sample(r *rand.Rand) uint {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + uint(r.Int63n(int64(d.Max-d.Min)+1))
+}
+
+// Exponential returns values with the given mean, most of them small.
+type Exponential struct{ Mean float64 }
+
+func (d Exponential) Sample(r *rand.Rand) uint {
+	return uint(math.Round(r.ExpFloat64() * d.Mean))
+}
+
+// Zipf returns values in [0, Max] following a power law with exponent S
+// (which must be > 1), i.e. a few articles get most of the engagement.
+// Create it with NewZipf.
+type Zipf struct {
+	S   float64
+	Max uint64
+
+	mu  sync.Mutex // Guards src while sampling.
+	src *randSource
+	z   *rand.Zipf
+}
+
+func NewZipf(s float64, max uint64) *Zipf {
+	src := new(randSource)
+	return &Zipf{S: s, Max: max, src: src, z: rand.NewZipf(rand.New(src), s, 1, max)}
+}
+
+func (d *Zipf) Sample(r *rand.Rand) uint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The generator is built once, drawing from r for each sample.
+	d.src.r = r
+	return uint(d.z.Uint64())
+}
+
+// randSource is a rand.Source drawing from r, which can be swapped.
+type randSource struct{ r *rand.Rand }
+
+func (s *randSource) Int63() int64 { return s.r.Int63() }
+
+func (s *randSource) Seed(seed int64) {}
+
+// ParseDistribution parses distributions such as `const:3`,
+// `uniform:0:100`, `exp:50` and `zipf:1.5:10000`.
+func ParseDistribution(s string) (Distribution, error) {
+	parts := strings.Split(s, ":")
+
+	nums := make([]float64, len(parts)-1)
+	for i, p := range parts[1:] {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid distribution `%s`: bad parameter `%s`", s, p)
+		}
+		nums[i] = n
+	}
+
+	switch {
+	case parts[0] == "const" && len(nums) == 1:
+		return Constant{N: uint(nums[0])}, nil
+	case parts[0] == "uniform" && len(nums) == 2 && nums[0] <= nums[1]:
+		return Uniform{Min: uint(nums[0]), Max: uint(nums[1])}, nil
+	case parts[0] == "exp" && len(nums) == 1:
+		return Exponential{Mean: nums[0]}, nil
+	case parts[0] == "zipf" && len(nums) == 2 && nums[0] > 1:
+		return NewZipf(nums[0], uint64(nums[1])), nil
+	}
+
+	return nil, errors.Errorf("invalid distribution `%s`, expected const:N, uniform:MIN:MAX, exp:MEAN or zipf:S:MAX", s)
+}
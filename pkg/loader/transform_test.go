@@ -0,0 +1,126 @@
+package loader
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformers(t *testing.T) {
+	r := require.New(t)
+
+	newArticle := func() *domain.NYTimesArticle {
+		a := &domain.NYTimesArticle{
+			ID:            "nyt://article/1",
+			Abstract:      "  Tom &amp; Jerry\n\tare   back. ",
+			LeadParagraph: "Lead",
+			PubDate:       "2000-01-01T00:00:00+0000",
+			Keywords: []domain.Keyword{
+				{Name: "subject", Value: "Cartoons"},
+				{Name: "persons", Value: "Tom"},
+				{Name: "glocations", Value: "Tokyo (Japan)"},
+			},
+		}
+		a.Headline.Main = "Headline"
+		return a
+	}
+
+	a := newArticle()
+	doc := NewSearchArticle(a)
+	r.Equal([]string{"Cartoons", "Tom", "Tokyo (Japan)"}, doc.Keywords)
+	r.True(doc.IsPublished)
+	r.Zero(doc.NumLikes)
+
+	chain, err := ParseTransformers(DefaultTransformers, TransformOptions{})
+	r.NoError(err)
+	r.NoError(chain.Transform(a, doc))
+	r.Equal(uint(3), doc.NumLikes)
+	r.Equal(uint(1), doc.NumComments)
+
+	chain, err = ParseTransformers("normalise, typed-keywords", TransformOptions{})
+	r.NoError(err)
+	r.NoError(chain.Transform(a, doc))
+	r.Equal("Tom & Jerry are back.", doc.Abstract)
+	r.Equal([]string{"subject:Cartoons", "persons:Tom", "glocations:Tokyo (Japan)"}, doc.Keywords)
+
+	_, err = ParseTransformers("normalise,nope", TransformOptions{})
+	r.ErrorContains(err, "unknown transformer `nope`")
+
+	// Engagement metrics are the same for the same article.
+	e := &Engagement{Likes: Uniform{Min: 10, Max: 20}, Comments: Constant{N: 7}}
+	r.NoError(e.Transform(a, doc))
+	r.GreaterOrEqual(doc.NumLikes, uint(10))
+	r.LessOrEqual(doc.NumLikes, uint(20))
+	r.Equal(uint(7), doc.NumComments)
+	likes := doc.NumLikes
+	r.NoError(e.Transform(newArticle(), doc))
+	r.Equal(likes, doc.NumLikes)
+
+	r.NoError(Fields{"headline"}.Transform(a, doc))
+	r.Equal(&domain.SearchArticle{ID: a.ID, PubDate: a.PubDate, Headline: "Headline"}, doc)
+	r.Error(Fields{"nope"}.Transform(a, doc))
+
+	_, err = ParseTransformers("fields", TransformOptions{})
+	r.ErrorContains(err, "needs at least one field")
+	chain, err = ParseTransformers("fields", TransformOptions{Fields: []string{"abstract"}})
+	r.NoError(err)
+	r.Equal(Chain{Fields{"abstract"}}, chain)
+
+	reg := DefaultRegistry()
+	reg.Register("custom", TransformerFunc(func(a *domain.NYTimesArticle, doc *domain.SearchArticle) error {
+		doc.Headline = "Custom"
+		return nil
+	}))
+	chain, err = ParseTransformers("custom", TransformOptions{Registry: reg})
+	r.NoError(err)
+	r.NoError(chain.Transform(a, doc))
+	r.Equal("Custom", doc.Headline)
+
+	// Registries are independent of each other.
+	_, err = ParseTransformers("custom", TransformOptions{})
+	r.ErrorContains(err, "unknown transformer `custom`")
+}
+
+func TestParseDistribution(t *testing.T) {
+	r := require.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for s, expected := range map[string]Distribution{
+		"const:3":       Constant{N: 3},
+		"uniform:0:100": Uniform{Min: 0, Max: 100},
+		"exp:50":        Exponential{Mean: 50},
+	} {
+		d, err := ParseDistribution(s)
+		r.NoError(err, s)
+		r.Equal(expected, d, s)
+		d.Sample(rnd)
+	}
+
+	for _, s := range []string{"", "const", "uniform:5:1", "exp:-1", "zipf:1:100", "normal:1:2", "exp:x"} {
+		_, err := ParseDistribution(s)
+		r.Error(err, s)
+	}
+
+	d, err := ParseDistribution("zipf:1.5:10000")
+	r.NoError(err)
+	z, ok := d.(*Zipf)
+	r.True(ok)
+	r.Equal(1.5, z.S)
+	r.Equal(uint64(10_000), z.Max)
+
+	// Zipf samples only depend on the rand passed in.
+	for i := int64(0); i < 100; i++ {
+		a := z.Sample(rand.New(rand.NewSource(i)))
+		r.LessOrEqual(a, uint(10_000))
+		r.Equal(a, z.Sample(rand.New(rand.NewSource(i))))
+	}
+
+	var sum uint
+	for i := 0; i < 10_000; i++ {
+		sum += Exponential{Mean: 50}.Sample(rnd)
+	}
+	r.InDelta(50, float64(sum)/10_000, 5)
+}
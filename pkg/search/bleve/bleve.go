@@ -0,0 +1,264 @@
+// Bleve package implements an embedded search engine backend using
+// github.com/blevesearch/bleve, as an alternative to ES that needs no
+// running cluster.
+package bleve
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	blevesearch "github.com/blevesearch/bleve/v2"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// Bleve stores each index in a directory named after the index under its
+// path.
+type Bleve struct {
+	path          string
+	verboseOutput bool
+
+	mu      sync.Mutex // Guards indexes.
+	indexes map[string]blevesearch.Index
+
+	stats domain.BulkStats
+}
+
+func New(path string, verboseOutput bool) *Bleve {
+	return &Bleve{
+		path:          path,
+		verboseOutput: verboseOutput,
+		indexes:       make(map[string]blevesearch.Index),
+	}
+}
+
+// IndexPath returns the directory the given index is stored in.
+func (s *Bleve) IndexPath(indexName string) string {
+	return filepath.Join(s.path, indexName)
+}
+
+// index returns the given index, opening it if needed.
+func (s *Bleve) index(indexName string) (blevesearch.Index, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idx, ok := s.indexes[indexName]; ok {
+		return idx, nil
+	}
+
+	idx, err := blevesearch.Open(s.IndexPath(indexName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open index %s", indexName)
+	}
+
+	s.indexes[indexName] = idx
+
+	return idx, nil
+}
+
+func (s *Bleve) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error {
+	m, err := MappingFromFile(mappingsJSONFile)
+	if err != nil {
+		return err
+	}
+
+	err = s.DeleteIndex(ctx, indexName)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.MkdirAll(s.path, 0o755)
+	if err != nil {
+		return errors.Wrapf(err, "could not create dir %s", s.path)
+	}
+
+	idx, err := blevesearch.New(s.IndexPath(indexName), m)
+	if err != nil {
+		return errors.Wrapf(err, "could not create index %s", indexName)
+	}
+
+	s.indexes[indexName] = idx
+
+	fmt.Printf("Created new index `%s` in %s\n", indexName, s.IndexPath(indexName))
+
+	return nil
+}
+
+func (s *Bleve) DeleteIndex(ctx context.Context, indexName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idx, ok := s.indexes[indexName]; ok {
+		delete(s.indexes, indexName)
+
+		err := idx.Close()
+		if err != nil {
+			return errors.Wrapf(err, "could not close index %s", indexName)
+		}
+	}
+
+	err := os.RemoveAll(s.IndexPath(indexName))
+	if err != nil {
+		return errors.Wrapf(err, "could not delete index %s", indexName)
+	}
+
+	fmt.Printf("Deleted existing index `%s`\n", indexName)
+
+	return nil
+}
+
+// BulkIndex indexes docs in a single batch. Docs that can't be analysed are
+// listed in the result and not indexed.
+func (s *Bleve) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	if err := domain.CheckBulk(docIDs, docs); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	idx, err := s.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.Now()
+
+	result := new(domain.BulkResult)
+	b := idx.NewBatch()
+
+	for i, id := range docIDs {
+		doc := docs[i]
+
+		// Bleve indexes structs as they are, only raw JSON is decoded.
+		if _, ok := doc.(json.RawMessage); ok {
+			var m map[string]interface{}
+
+			err := domain.DecodeDoc(doc, &m)
+			if err != nil {
+				result.Failures = append(result.Failures, domain.InvalidDoc(i, id, err))
+				continue
+			}
+
+			doc = m
+		}
+
+		err := b.Index(id, doc)
+		if err != nil {
+			result.Failures = append(result.Failures, domain.BulkFailure{
+				Pos:    i,
+				DocID:  id,
+				Status: 400,
+				Type:   "index_error",
+				Reason: err.Error(),
+			})
+			continue
+		}
+
+		result.Indexed++
+	}
+
+	err = idx.Batch(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not bulk index")
+	}
+
+	s.stats.Add(result.Indexed, time.Since(timer))
+
+	if s.verboseOutput {
+		fmt.Printf("Bulk indexed %d docs (%d failed)\n", result.Indexed, len(result.Failures))
+	}
+
+	return result, nil
+}
+
+func (s *Bleve) Refresh(ctx context.Context, indexName string) error {
+	_, err := s.index(indexName)
+	return err
+}
+
+func (s *Bleve) Count(ctx context.Context, indexName string) (int, error) {
+	idx, err := s.index(indexName)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := idx.DocCount()
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not count docs in index %s", indexName)
+	}
+
+	return int(n), nil
+}
+
+func (s *Bleve) PrintBulkIndexingRate() {
+	s.stats.Print()
+}
+
+// Close closes all open indexes.
+func (s *Bleve) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+
+	for name, idx := range s.indexes {
+		delete(s.indexes, name)
+
+		err := idx.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "could not close index %s", name)
+		}
+	}
+
+	return firstErr
+}
+
+// Search runs an ES style JSON query, see ParseQuery for what's supported.
+func (s *Bleve) Search(ctx context.Context, indexName string, queryJSON []byte) (*domain.SearchResult, error) {
+	req, err := ParseQuery(queryJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := s.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := idx.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not search index %s", indexName)
+	}
+
+	sr := &domain.SearchResult{Total: int(res.Total)}
+
+	for _, h := range res.Hits {
+		sr.Hits = append(sr.Hits, domain.SearchHit{ID: h.ID, Score: h.Score, Source: h.Fields})
+	}
+
+	for name, f := range res.Facets {
+		if sr.Facets == nil {
+			sr.Facets = make(map[string][]domain.FacetTerm)
+		}
+
+		terms := []domain.FacetTerm{}
+		if f.Terms != nil {
+			for _, t := range f.Terms.Terms() {
+				terms = append(terms, domain.FacetTerm{Term: t.Term, Count: t.Count})
+			}
+		}
+
+		sr.Facets[name] = terms
+	}
+
+	return sr, nil
+}
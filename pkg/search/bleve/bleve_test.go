@@ -0,0 +1,29 @@
+package bleve
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/search/searchtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBleve(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := New(t.TempDir(), false)
+	defer s.Close()
+
+	searchtest.Run(t, s)
+
+	// Indexes can be reopened.
+	r.NoError(s.Close())
+
+	n, err := s.Count(ctx, searchtest.IndexName)
+	r.NoError(err)
+	r.Equal(4, n)
+
+	r.NoError(s.DeleteIndex(ctx, searchtest.IndexName))
+	r.NoDirExists(s.IndexPath(searchtest.IndexName))
+}
@@ -0,0 +1,107 @@
+package bleve
+
+import (
+	"os"
+	"time"
+
+	blevesearch "github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/datetime/flexible"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// Name of the date parser used for date fields, accepting the NY Times
+// `2006-01-02T15:04:05+0000` format as well as RFC 3339.
+const dateParser = "nytimes"
+
+// esMappings is the subset of an ES index mappings file used to derive a
+// Bleve mapping.
+type esMappings struct {
+	Mappings struct {
+		Dynamic    interface{}               `json:"dynamic"`
+		Properties map[string]esFieldMapping `json:"properties"`
+	} `json:"mappings"`
+}
+
+type esFieldMapping struct {
+	Type string `json:"type"`
+}
+
+// MappingFromFile reads an ES index mappings file and converts it to a
+// Bleve mapping, see NewMapping.
+func MappingFromFile(file string) (*mapping.IndexMappingImpl, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read mappings")
+	}
+
+	m, err := NewMapping(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert mappings in %s", file)
+	}
+
+	return m, nil
+}
+
+// NewMapping converts ES index mappings to a Bleve mapping, field by field:
+//
+//   - text fields use the standard analyzer
+//   - keyword fields are indexed as is
+//   - boolean, date and numeric fields map to their Bleve counterparts
+//   - flattened fields are indexed dynamically, with strings indexed as is
+//
+// With `"dynamic": "strict"` unmapped fields are ignored, as Bleve can't
+// reject docs with unmapped fields.
+func NewMapping(esMappingsJSON []byte) (*mapping.IndexMappingImpl, error) {
+	var em esMappings
+
+	err := json.Unmarshal(esMappingsJSON, &em)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode mappings")
+	}
+
+	im := blevesearch.NewIndexMapping()
+
+	err = im.AddCustomDateTimeParser(dateParser, map[string]interface{}{
+		"type":    flexible.Name,
+		"layouts": []interface{}{"2006-01-02T15:04:05-0700", time.RFC3339Nano, "2006-01-02"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not add date parser")
+	}
+
+	dm := blevesearch.NewDocumentMapping()
+	switch em.Mappings.Dynamic {
+	case false, "false", "strict":
+		dm.Dynamic = false
+	}
+
+	for name, f := range em.Mappings.Properties {
+		switch f.Type {
+		case "text":
+			dm.AddFieldMappingsAt(name, blevesearch.NewTextFieldMapping())
+		case "keyword":
+			dm.AddFieldMappingsAt(name, blevesearch.NewKeywordFieldMapping())
+		case "boolean":
+			dm.AddFieldMappingsAt(name, blevesearch.NewBooleanFieldMapping())
+		case "date":
+			fm := blevesearch.NewDateTimeFieldMapping()
+			fm.DateFormat = dateParser
+			dm.AddFieldMappingsAt(name, fm)
+		case "integer", "long", "short", "byte", "float", "double", "half_float", "scaled_float":
+			dm.AddFieldMappingsAt(name, blevesearch.NewNumericFieldMapping())
+		case "flattened", "object":
+			sub := blevesearch.NewDocumentMapping()
+			sub.DefaultAnalyzer = keyword.Name
+			dm.AddSubDocumentMapping(name, sub)
+		default:
+			return nil, errors.Errorf("unsupported type `%s` for field %s", f.Type, name)
+		}
+	}
+
+	im.DefaultMapping = dm
+
+	return im, nil
+}
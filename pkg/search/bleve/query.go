@@ -0,0 +1,196 @@
+package bleve
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/search/esquery"
+	blevesearch "github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/pkg/errors"
+)
+
+// ParseQuery converts an ES style JSON search request to a Bleve search
+// request, see esquery.Parse for what's supported. Terms aggregations are
+// converted to facets.
+func ParseQuery(queryJSON []byte) (*blevesearch.SearchRequest, error) {
+	r, err := esquery.Parse(queryJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := convertQuery(r.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	req := blevesearch.NewSearchRequestOptions(q, r.Size, r.From, false)
+	req.Fields = []string{"*"}
+
+	for name, a := range r.Aggs {
+		req.AddFacet(name, blevesearch.NewFacetRequest(a.Field, a.Size))
+	}
+
+	return req, nil
+}
+
+func convertQuery(q esquery.Query) (query.Query, error) {
+	switch q := q.(type) {
+	case *esquery.MatchAll:
+		return boosted(blevesearch.NewMatchAllQuery(), q.Boost), nil
+
+	case *esquery.Match:
+		mq := blevesearch.NewMatchQuery(q.Text)
+		mq.SetField(q.Field)
+		if q.And {
+			mq.SetOperator(query.MatchQueryOperatorAnd)
+		}
+		return boosted(mq, q.Boost), nil
+
+	case *esquery.MatchPhrase:
+		pq := blevesearch.NewMatchPhraseQuery(q.Text)
+		pq.SetField(q.Field)
+		return boosted(pq, q.Boost), nil
+
+	case *esquery.Term:
+		return boosted(termQuery(q.Field, q.Value), q.Boost), nil
+
+	case *esquery.Terms:
+		var qs []query.Query
+		for _, v := range q.Values {
+			qs = append(qs, termQuery(q.Field, v))
+		}
+		return boosted(blevesearch.NewDisjunctionQuery(qs...), q.Boost), nil
+
+	case *esquery.Range:
+		return rangeQuery(q)
+
+	case *esquery.Bool:
+		return boolQuery(q)
+
+	case *esquery.ConstantScore:
+		// Bleve has no constant score queries, so the filter is scored.
+		return convertQuery(q.Filter)
+
+	default:
+		return nil, errors.Errorf("unsupported query %T", q)
+	}
+}
+
+// boosted sets the boost of a query, if set.
+func boosted(q query.Query, boost float64) query.Query {
+	if boost != 0 {
+		q.(query.BoostableQuery).SetBoost(boost)
+	}
+	return q
+}
+
+// termQuery returns a query matching a boolean, number or keyword exactly.
+func termQuery(field string, value interface{}) query.Query {
+	var q query.FieldableQuery
+
+	switch v := value.(type) {
+	case bool:
+		q = blevesearch.NewBoolFieldQuery(v)
+	case float64:
+		incl := true
+		q = blevesearch.NewNumericRangeInclusiveQuery(&v, &v, &incl, &incl)
+	default:
+		q = blevesearch.NewTermQuery(fmt.Sprint(v))
+	}
+
+	q.SetField(field)
+	return q
+}
+
+func convertQueries(qs []esquery.Query) ([]query.Query, error) {
+	var converted []query.Query
+
+	for _, q := range qs {
+		c, err := convertQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, c)
+	}
+
+	return converted, nil
+}
+
+func boolQuery(q *esquery.Bool) (query.Query, error) {
+	bq := blevesearch.NewBooleanQuery()
+
+	// Bleve has no non-scoring clauses, so filters also affect scores.
+	for _, clause := range []struct {
+		qs  []esquery.Query
+		add func(...query.Query)
+	}{
+		{q.Must, bq.AddMust},
+		{q.Filter, bq.AddMust},
+		{q.Should, bq.AddShould},
+		{q.MustNot, bq.AddMustNot},
+	} {
+		qs, err := convertQueries(clause.qs)
+		if err != nil {
+			return nil, err
+		}
+		if len(qs) > 0 {
+			clause.add(qs...)
+		}
+	}
+
+	if q.MinimumShouldMatch > 0 {
+		bq.SetMinShould(float64(q.MinimumShouldMatch))
+	}
+
+	return boosted(bq, q.Boost), nil
+}
+
+// rangeQuery converts a numeric or date range query. If both an exclusive
+// and an inclusive bound are given on the same side the exclusive one is
+// used.
+func rangeQuery(q *esquery.Range) (query.Query, error) {
+	min, minIncl := q.GTE, true
+	if q.GT != nil {
+		min, minIncl = q.GT, false
+	}
+	max, maxIncl := q.LTE, true
+	if q.LT != nil {
+		max, maxIncl = q.LT, false
+	}
+
+	if q.IsDate() {
+		var start, end time.Time
+
+		for _, b := range []struct {
+			bound interface{}
+			t     *time.Time
+		}{{min, &start}, {max, &end}} {
+			if b.bound == nil {
+				continue
+			}
+
+			t, err := q.Date(b.bound)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid range on field %s", q.Field)
+			}
+			*b.t = t
+		}
+
+		dq := blevesearch.NewDateRangeInclusiveQuery(start, end, &minIncl, &maxIncl)
+		dq.SetField(q.Field)
+		return boosted(dq, q.Boost), nil
+	}
+
+	nq := blevesearch.NewNumericRangeInclusiveQuery(toFloat(min), toFloat(max), &minIncl, &maxIncl)
+	nq.SetField(q.Field)
+	return boosted(nq, q.Boost), nil
+}
+
+func toFloat(v interface{}) *float64 {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	return &f
+}
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/anrid/nytimes/pkg/util"
+	"github.com/anrid/nytimes/pkg/domain"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -23,10 +25,8 @@ var (
 type ES struct {
 	es *elasticsearch.Client
 
-	bulkIndexDocs       int64
-	bulkIndexSecs       float64
-	bulkIndexLatestRate float64
-	verboseOutput       bool
+	stats         domain.BulkStats
+	verboseOutput bool
 }
 
 func New(addrs []string, verboseOutput bool) *ES {
@@ -100,26 +100,90 @@ func (s *ES) Search(ctx context.Context, queryJSON []byte, indexName string, use
 	return
 }
 
-func (s *ES) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) {
+func (s *ES) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error {
+	mappings, err := os.ReadFile(mappingsJSONFile)
+	if err != nil {
+		return errors.Wrap(err, "could not read mappings")
+	}
+
 	// Delete test index if it exists.
+	err = s.DeleteIndex(ctx, indexName)
+	if err != nil {
+		return err
+	}
+
+	// Create a new test index.
+	res, err := esapi.IndicesCreateRequest{
+		Index:  indexName,
+		Body:   bytes.NewReader(mappings),
+		Pretty: true,
+	}.Do(ctx, s.es)
+	err = responseError(res, err)
+	if err != nil {
+		return errors.Wrapf(err, "could not create index %s", indexName)
+	}
+	defer res.Body.Close()
+
+	fmt.Printf("Created new index `%s` (status: %d)\n", indexName, res.StatusCode)
+
+	return nil
+}
+
+func (s *ES) DeleteIndex(ctx context.Context, indexName string) error {
 	res, err := esapi.IndicesDeleteRequest{
 		Index:             []string{indexName},
 		IgnoreUnavailable: &truee,
 		Pretty:            true,
 	}.Do(ctx, s.es)
-	PanicOnError(res, err)
+	err = responseError(res, err)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete index %s", indexName)
+	}
+	defer res.Body.Close()
 
 	fmt.Printf("Deleted existing index `%s` (status: %d)\n", indexName, res.StatusCode)
 
-	// Create a new test index.
-	res, err = esapi.IndicesCreateRequest{
-		Index:  indexName,
-		Body:   bytes.NewReader(ReadJSONFile(mappingsJSONFile)),
-		Pretty: true,
+	return nil
+}
+
+func (s *ES) Refresh(ctx context.Context, indexName string) error {
+	res, err := esapi.IndicesRefreshRequest{
+		Index: []string{indexName},
 	}.Do(ctx, s.es)
-	PanicOnError(res, err)
+	err = responseError(res, err)
+	if err != nil {
+		return errors.Wrapf(err, "could not refresh index %s", indexName)
+	}
+	res.Body.Close()
 
-	fmt.Printf("Created new index `%s` (status: %d)\n", indexName, res.StatusCode)
+	return nil
+}
+
+func (s *ES) Count(ctx context.Context, indexName string) (int, error) {
+	res, err := esapi.CountRequest{
+		Index: []string{indexName},
+	}.Do(ctx, s.es)
+	err = responseError(res, err)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not count docs in index %s", indexName)
+	}
+	defer res.Body.Close()
+
+	var cr struct {
+		Count int `json:"count"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&cr)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not decode count response")
+	}
+
+	return cr.Count, nil
+}
+
+// Close is a no-op, the ES client holds no resources that need releasing.
+func (s *ES) Close() error {
+	return nil
 }
 
 func (s *ES) Stats(ctx context.Context) (sr StatsResponse) {
@@ -131,9 +195,13 @@ func (s *ES) Stats(ctx context.Context) (sr StatsResponse) {
 	return
 }
 
-func (s *ES) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) {
-	if len(docIDs) == 0 || len(docIDs) != len(docs) {
-		log.Fatalf("got %d doc IDs but %d docs", len(docIDs), len(docs))
+// BulkIndex indexes docs in bulk. If ES rejects the whole bulk with a 429
+// the error wraps domain.ErrBulkRejected and the bulk may be retried. Docs
+// that fail individually are listed in the result.
+func (s *ES) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	err := domain.CheckBulk(docIDs, docs)
+	if err != nil {
+		return nil, err
 	}
 
 	// Bulk index documents.
@@ -148,9 +216,14 @@ func (s *ES) BulkIndex(ctx context.Context, indexName string, docIDs []string, d
 		sb.WriteString(`"}}`)
 		sb.WriteRune('\n')
 
-		docJ, err := json.Marshal(docs[i])
-		if err != nil {
-			log.Fatalf("could not marshal doc id %s : %s", id, err)
+		// Docs may already be serialized, see loader.Loader.MaxBulkBytes.
+		docJ, ok := docs[i].(json.RawMessage)
+		if !ok {
+			var err error
+			docJ, err = json.Marshal(docs[i])
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not marshal doc id %s", id)
+			}
 		}
 
 		sb.Write(docJ)
@@ -163,29 +236,75 @@ func (s *ES) BulkIndex(ctx context.Context, indexName string, docIDs []string, d
 		Index: indexName,
 		Body:  strings.NewReader(sb.String()),
 	}.Do(ctx, s.es)
-	PanicOnError(res, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not bulk index")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, errors.Wrapf(domain.ErrBulkRejected, "got status %d", res.StatusCode)
+	}
+	if res.IsError() {
+		return nil, errors.Errorf("error response: %s", res)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read bulk response")
+	}
 
-	br := make(map[string]interface{})
-	Unmarshal(res, &br)
+	br := new(BulkResponse)
 
-	if errs, ok := br["errors"].(bool); ok && errs {
-		util.Dump(br)
-		log.Panic("error while bulk indexing")
+	err = json.Unmarshal(data, br)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode bulk response: %s", string(data))
 	}
 
-	elapsed := time.Since(timer).Seconds()
+	result := &domain.BulkResult{Indexed: int(count)}
+
+	if br.Errors {
+		for i, it := range br.Items {
+			item := it.Index
+			if item.Error.Type == "" {
+				item = it.Create
+			}
+			if item.Error.Type == "" {
+				continue
+			}
+
+			id := item.ID
+			if id == "" && i < len(docIDs) {
+				id = docIDs[i]
+			}
+
+			result.Failures = append(result.Failures, domain.BulkFailure{
+				Pos:       i,
+				DocID:     id,
+				Status:    item.Status,
+				Type:      item.Error.Type,
+				Reason:    item.Error.Reason,
+				Retriable: item.Retriable(),
+			})
+		}
+
+		result.Indexed -= len(result.Failures)
+
+		if s.verboseOutput {
+			fmt.Printf("Bulk had %d failed docs\n", len(result.Failures))
+		}
+	}
 
-	s.bulkIndexDocs += count
-	s.bulkIndexSecs += elapsed
-	s.bulkIndexLatestRate = float64(count) / elapsed
+	s.stats.Add(result.Indexed, time.Since(timer))
 
 	if s.verboseOutput {
-		fmt.Printf("Bulk indexed %d docs (status: %d)\n", count, res.StatusCode)
+		fmt.Printf("Bulk indexed %d docs (status: %d)\n", result.Indexed, res.StatusCode)
 	}
+
+	return result, nil
 }
 
 func (s *ES) PrintBulkIndexingRate() {
-	fmt.Printf("Bulk indexing rate: %.02f docs / sec  (avg: %.02f)\n", s.bulkIndexLatestRate, float64(s.bulkIndexDocs)/s.bulkIndexSecs)
+	s.stats.Print()
 }
 
 func ReadJSONFile(file string) []byte {
@@ -205,6 +324,19 @@ func PanicOnError(res *esapi.Response, err error) {
 	}
 }
 
+// responseError returns an error if a request failed or ES responded with
+// an error.
+func responseError(res *esapi.Response, err error) error {
+	if err != nil {
+		return errors.Wrap(err, "error getting response")
+	}
+	if res.IsError() {
+		defer res.Body.Close()
+		return errors.Errorf("error response: %s", res)
+	}
+	return nil
+}
+
 func Unmarshal(res *esapi.Response, o interface{}) {
 	data, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -220,11 +352,23 @@ func Unmarshal(res *esapi.Response, o interface{}) {
 type BulkResponse struct {
 	Errors bool `json:"errors"` // : false,
 	Items  []struct {
-		Index  struct{ Error ESError } `json:"index"`
-		Create struct{ Error ESError } `json:"create"`
+		Index  BulkItem `json:"index"`
+		Create BulkItem `json:"create"`
 	} `json:"items"`
 }
 
+type BulkItem struct {
+	ID     string  `json:"_id"`
+	Status int     `json:"status"`
+	Error  ESError `json:"error"`
+}
+
+// Retriable returns true if the item failed because ES was overloaded or
+// temporarily unavailable.
+func (it BulkItem) Retriable() bool {
+	return it.Status == http.StatusTooManyRequests || it.Status >= 500 || it.Error.Type == "es_rejected_execution_exception"
+}
+
 type ESError struct {
 	Type      string `json:"type"`       // Error type for the operation.
 	Reason    string `json:"reason"`     // Reason for the failed operation.
@@ -250,3 +394,69 @@ type StatsResponse struct {
 		} `json:"total"`
 	} `json:"_all"`
 }
+
+// Searcher adapts ES to domain.Searcher.
+type Searcher struct {
+	ES       *ES
+	UseCache bool
+}
+
+func (s *Searcher) Search(ctx context.Context, indexName string, queryJSON []byte) (*domain.SearchResult, error) {
+	res, err := esapi.SearchRequest{
+		Index:        []string{indexName},
+		Body:         bytes.NewReader(queryJSON),
+		RequestCache: &s.UseCache,
+	}.Do(ctx, s.ES.es)
+	err = responseError(res, err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not search index %s", indexName)
+	}
+	defer res.Body.Close()
+
+	var sr SearchResponse
+
+	err = json.NewDecoder(res.Body).Decode(&sr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode search response")
+	}
+
+	result := &domain.SearchResult{Total: sr.Hits.Total.Value}
+
+	for _, h := range sr.Hits.Hits {
+		result.Hits = append(result.Hits, domain.SearchHit{ID: h.ID, Score: h.Score, Source: h.Source})
+	}
+
+	for name, agg := range sr.Aggregations {
+		if result.Facets == nil {
+			result.Facets = make(map[string][]domain.FacetTerm)
+		}
+
+		terms := []domain.FacetTerm{}
+		for _, b := range agg.Buckets {
+			terms = append(terms, domain.FacetTerm{Term: fmt.Sprint(b.Key), Count: b.DocCount})
+		}
+
+		result.Facets[name] = terms
+	}
+
+	return result, nil
+}
+
+type SearchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			ID     string                 `json:"_id"`
+			Score  float64                `json:"_score"`
+			Source map[string]interface{} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]struct {
+		Buckets []struct {
+			Key      interface{} `json:"key"`
+			DocCount int         `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"aggregations"`
+}
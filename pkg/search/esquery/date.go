@@ -0,0 +1,155 @@
+package esquery
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Layouts accepted for date strings, most specific first.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Supported range formats, which can be combined like ES's default
+// `strict_date_optional_time||epoch_millis`.
+var dateFormats = map[string]bool{
+	"strict_date_optional_time": true,
+	"date_optional_time":        true,
+	"epoch_millis":              true,
+	"epoch_second":              true,
+}
+
+// IsDate returns true if the range has date bounds, i.e. string bounds or
+// a format. Numeric bounds of date fields are epoch millis, so backends
+// that know the field's type should check that instead.
+func (q *Range) IsDate() bool {
+	for _, v := range []interface{}{q.GT, q.GTE, q.LT, q.LTE} {
+		if _, ok := v.(string); ok {
+			return true
+		}
+	}
+	return q.Format != ""
+}
+
+// Date returns a bound as a time, in UTC. Date strings without an offset
+// are in TimeZone (default UTC) and numbers are epoch millis, unless
+// Format says otherwise. Date math like `now-1d` isn't supported.
+func (q *Range) Date(bound interface{}) (time.Time, error) {
+	loc, err := location(q.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, format := range strings.Split(q.Format, "||") {
+		t, ok := parseDate(bound, format, loc)
+		if ok {
+			return t.UTC(), nil
+		}
+	}
+
+	if q.Format != "" {
+		return time.Time{}, errors.Errorf("date `%v` doesn't match format %s", bound, q.Format)
+	}
+	return time.Time{}, errors.Errorf("unsupported date `%v`", bound)
+}
+
+// ParseDate returns a date string or epoch millis as a time, in UTC, the
+// way a range without a format or time zone would. Used for the values of
+// term queries on date fields.
+func ParseDate(v interface{}) (time.Time, error) {
+	return (&Range{}).Date(v)
+}
+
+// checkDateOptions returns an error if the range's format or time zone
+// isn't supported.
+func (q *Range) checkDateOptions() error {
+	if q.Format != "" {
+		for _, format := range strings.Split(q.Format, "||") {
+			if !dateFormats[format] {
+				return errors.Errorf("unsupported format `%s`", format)
+			}
+		}
+	}
+
+	_, err := location(q.TimeZone)
+	return err
+}
+
+// parseDate parses a date string or number using a single format, or the
+// default one if format is empty.
+func parseDate(v interface{}, format string, loc *time.Location) (time.Time, bool) {
+	switch format {
+	case "epoch_millis", "epoch_second":
+		var n float64
+		switch v := v.(type) {
+		case float64:
+			n = v
+		case string:
+			var err error
+			n, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				return time.Time{}, false
+			}
+		default:
+			return time.Time{}, false
+		}
+
+		if format == "epoch_second" {
+			n *= 1000
+		}
+		return time.UnixMilli(int64(n)), true
+
+	default:
+		switch v := v.(type) {
+		case float64:
+			if format != "" {
+				return time.Time{}, false
+			}
+			return time.UnixMilli(int64(v)), true
+
+		case string:
+			for _, layout := range dateLayouts {
+				t, err := time.ParseInLocation(layout, v, loc)
+				if err == nil {
+					return t, true
+				}
+			}
+		}
+
+		return time.Time{}, false
+	}
+}
+
+// location returns the location of an ES time zone, either an offset like
+// `+01:00` or a name like `Europe/Paris`.
+func location(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+
+	if tz[0] == '+' || tz[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			t, err := time.Parse(layout, tz)
+			if err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(tz, offset), nil
+			}
+		}
+		return nil, errors.Errorf("unsupported time_zone `%s`", tz)
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unsupported time_zone `%s`", tz)
+	}
+
+	return loc, nil
+}
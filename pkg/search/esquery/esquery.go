@@ -0,0 +1,414 @@
+// Package esquery parses ES style JSON search requests into a small
+// syntax tree, which each embedded search backend translates into its own
+// queries.
+package esquery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// Number of hits or terms returned when a request doesn't set `size`, same
+// as ES.
+const DefaultSize = 10
+
+// Query is a parsed query, one of the types below. Boosts multiply scores,
+// a boost of 0 means the boost isn't set.
+type Query interface {
+	query()
+}
+
+// MatchAll matches all docs.
+type MatchAll struct {
+	Boost float64
+}
+
+// Match matches docs with any of the words in Text in a field, or all of
+// them if And is set.
+type Match struct {
+	Field string
+	Text  string
+	And   bool
+	Boost float64
+}
+
+// MatchPhrase matches docs with all of the words in Text, in order, in a
+// field.
+type MatchPhrase struct {
+	Field string
+	Text  string
+	Boost float64
+}
+
+// Term matches docs with a field containing a value (a string, float64 or
+// bool) exactly.
+type Term struct {
+	Field string
+	Value interface{}
+	Boost float64
+}
+
+// Terms matches docs with a field containing any of the given values
+// exactly.
+type Terms struct {
+	Field  string
+	Values []interface{}
+	Boost  float64
+}
+
+// Range matches docs with a field value within the given bounds. Unset
+// bounds are nil, others are strings or float64s. Format and TimeZone only
+// apply to dates, see Date.
+type Range struct {
+	Field            string
+	GT, GTE, LT, LTE interface{}
+	Format           string
+	TimeZone         string
+	Boost            float64
+}
+
+// Bool combines queries: docs must match all Must and Filter queries, none
+// of the MustNot queries and at least MinimumShouldMatch of the Should
+// queries. Filter queries don't count towards scores.
+type Bool struct {
+	Must               []Query
+	Filter             []Query
+	Should             []Query
+	MustNot            []Query
+	MinimumShouldMatch int
+	Boost              float64
+}
+
+// ConstantScore matches the docs matching Filter, with a constant score.
+type ConstantScore struct {
+	Filter Query
+	Boost  float64
+}
+
+func (*MatchAll) query()      {}
+func (*Match) query()         {}
+func (*MatchPhrase) query()   {}
+func (*Term) query()          {}
+func (*Terms) query()         {}
+func (*Range) query()         {}
+func (*Bool) query()          {}
+func (*ConstantScore) query() {}
+
+// Request is a parsed search request.
+type Request struct {
+	Query Query // MatchAll if the request has no query.
+	From  int
+	Size  int                 // DefaultSize if the request has no size.
+	Aggs  map[string]TermsAgg // By name.
+}
+
+// TermsAgg counts the most common values of a field in all matching docs.
+type TermsAgg struct {
+	Field string
+	Size  int // DefaultSize if the aggregation has no size.
+}
+
+// Parse parses an ES style JSON search request. Supported queries are
+// match_all, match, match_phrase, term, terms, range, bool, constant_score
+// and function_score (of which only the inner query is used, so scores
+// differ from ES). Only terms aggregations are supported.
+func Parse(queryJSON []byte) (*Request, error) {
+	type termsAgg struct {
+		Terms *struct {
+			Field string `json:"field"`
+			Size  int    `json:"size"`
+		} `json:"terms"`
+	}
+
+	var body struct {
+		Query        map[string]interface{} `json:"query"`
+		Size         *int                   `json:"size"`
+		From         int                    `json:"from"`
+		Aggs         map[string]termsAgg    `json:"aggs"`
+		Aggregations map[string]termsAgg    `json:"aggregations"`
+	}
+
+	err := json.Unmarshal(queryJSON, &body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode query")
+	}
+
+	req := &Request{Query: &MatchAll{}, From: body.From, Size: DefaultSize}
+
+	if body.Query != nil {
+		req.Query, err = convertQuery(body.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if body.Size != nil {
+		req.Size = *body.Size
+	}
+
+	if body.Aggs == nil {
+		body.Aggs = body.Aggregations
+	}
+
+	for name, a := range body.Aggs {
+		if a.Terms == nil {
+			return nil, errors.Errorf("unsupported aggregation %s, only terms aggregations are supported", name)
+		}
+
+		size := a.Terms.Size
+		if size == 0 {
+			size = DefaultSize
+		}
+
+		if req.Aggs == nil {
+			req.Aggs = make(map[string]TermsAgg)
+		}
+		req.Aggs[name] = TermsAgg{Field: a.Terms.Field, Size: size}
+	}
+
+	return req, nil
+}
+
+func convertQuery(q map[string]interface{}) (Query, error) {
+	if len(q) != 1 {
+		return nil, errors.Errorf("expected a single query type, got %d", len(q))
+	}
+
+	for typ, v := range q {
+		switch typ {
+		case "match_all":
+			opts, _ := v.(map[string]interface{})
+			return &MatchAll{Boost: boostOpt(opts)}, nil
+
+		case "match":
+			field, value, opts, err := fieldValue(typ, v)
+			if err != nil {
+				return nil, err
+			}
+			op, _ := opts["operator"].(string)
+			return &Match{Field: field, Text: fmt.Sprint(value), And: strings.EqualFold(op, "and"), Boost: boostOpt(opts)}, nil
+
+		case "match_phrase":
+			field, value, opts, err := fieldValue(typ, v)
+			if err != nil {
+				return nil, err
+			}
+			return &MatchPhrase{Field: field, Text: fmt.Sprint(value), Boost: boostOpt(opts)}, nil
+
+		case "term":
+			field, value, opts, err := fieldValue(typ, v)
+			if err != nil {
+				return nil, err
+			}
+			return &Term{Field: field, Value: value, Boost: boostOpt(opts)}, nil
+
+		case "terms":
+			return termsQuery(v)
+
+		case "range":
+			return rangeQuery(v)
+
+		case "bool":
+			return boolQuery(v)
+
+		case "constant_score":
+			opts, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("expected an object for constant_score query")
+			}
+			filter, err := nestedQuery(typ, opts["filter"])
+			if err != nil {
+				return nil, err
+			}
+			return &ConstantScore{Filter: filter, Boost: boostOpt(opts)}, nil
+
+		case "function_score":
+			opts, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("expected an object for function_score query")
+			}
+			if opts["query"] == nil {
+				return &MatchAll{}, nil
+			}
+			return nestedQuery(typ, opts["query"])
+
+		default:
+			return nil, errors.Errorf("unsupported query type `%s`", typ)
+		}
+	}
+
+	panic("unreachable")
+}
+
+func nestedQuery(typ string, v interface{}) (Query, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected a query object in %s query", typ)
+	}
+	return convertQuery(m)
+}
+
+// nestedQueries converts a query object or a list of query objects.
+func nestedQueries(typ string, v interface{}) ([]Query, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+
+	var qs []Query
+	for _, item := range list {
+		q, err := nestedQuery(typ, item)
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+	}
+
+	return qs, nil
+}
+
+func termsQuery(v interface{}) (Query, error) {
+	opts, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected an object for terms query")
+	}
+
+	q := &Terms{Boost: boostOpt(opts)}
+
+	for k, fv := range opts {
+		if k == "boost" {
+			continue
+		}
+		if q.Field != "" {
+			return nil, errors.New("expected a single field for terms query")
+		}
+
+		q.Field = k
+		q.Values, ok = fv.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("expected a list of terms for field %s", k)
+		}
+	}
+	if q.Field == "" {
+		return nil, errors.New("expected a single field for terms query")
+	}
+
+	return q, nil
+}
+
+func rangeQuery(v interface{}) (Query, error) {
+	field, value, _, err := fieldValue("range", v)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected an object for range query on field %s", field)
+	}
+
+	q := &Range{Field: field, Boost: boostOpt(opts)}
+
+	for op, ov := range opts {
+		switch op {
+		case "gt", "gte", "lt", "lte":
+			switch ov.(type) {
+			case string, float64:
+			default:
+				return nil, errors.Errorf("expected a string or number for range option `%s` on field %s", op, field)
+			}
+		case "format", "time_zone":
+			if _, ok := ov.(string); !ok {
+				return nil, errors.Errorf("expected a string for range option `%s` on field %s", op, field)
+			}
+		case "boost":
+		default:
+			return nil, errors.Errorf("unsupported range option `%s` on field %s", op, field)
+		}
+	}
+
+	q.GT, q.GTE, q.LT, q.LTE = opts["gt"], opts["gte"], opts["lt"], opts["lte"]
+	q.Format, _ = opts["format"].(string)
+	q.TimeZone, _ = opts["time_zone"].(string)
+
+	err = q.checkDateOptions()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid range on field %s", field)
+	}
+
+	return q, nil
+}
+
+func boolQuery(v interface{}) (Query, error) {
+	opts, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected an object for bool query")
+	}
+
+	q := &Bool{Boost: boostOpt(opts)}
+
+	for clause, qv := range opts {
+		var err error
+
+		switch clause {
+		case "must":
+			q.Must, err = nestedQueries("bool.must", qv)
+		case "filter":
+			q.Filter, err = nestedQueries("bool.filter", qv)
+		case "should":
+			q.Should, err = nestedQueries("bool.should", qv)
+		case "must_not":
+			q.MustNot, err = nestedQueries("bool.must_not", qv)
+		case "minimum_should_match":
+			n, ok := qv.(float64)
+			if !ok {
+				return nil, errors.New("only numeric minimum_should_match is supported")
+			}
+			q.MinimumShouldMatch = int(n)
+		case "boost":
+		default:
+			return nil, errors.Errorf("unsupported bool clause `%s`", clause)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// fieldValue returns the only field and value in a `{"field": value}` or
+// `{"field": {"query": value, ...}}` object, along with any options. The
+// value of a range query is its options.
+func fieldValue(typ string, v interface{}) (string, interface{}, map[string]interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", nil, nil, errors.Errorf("expected a single field for %s query", typ)
+	}
+
+	for field, value := range m {
+		opts, _ := value.(map[string]interface{})
+		if opts != nil && typ != "range" {
+			value = opts["query"]
+			if value == nil {
+				value = opts["value"]
+			}
+		}
+		if value == nil {
+			return "", nil, nil, errors.Errorf("missing value for %s query on field %s", typ, field)
+		}
+
+		return field, value, opts, nil
+	}
+
+	panic("unreachable")
+}
+
+// boostOpt returns the `boost` option of a query, or 0 if it isn't set.
+func boostOpt(opts map[string]interface{}) float64 {
+	b, _ := opts["boost"].(float64)
+	return b
+}
@@ -0,0 +1,114 @@
+package esquery
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const mappingsDir = "../../../assets/mappings/nytimes/"
+
+func TestParse(t *testing.T) {
+	r := require.New(t)
+
+	req, err := Parse([]byte(`{}`))
+	r.NoError(err)
+	r.Equal(&Request{Query: &MatchAll{}, Size: DefaultSize}, req)
+
+	req, err = Parse([]byte(`{
+		"from": 5,
+		"size": 20,
+		"query": {"bool": {
+			"must": {"match": {"headline": {"query": "obama japan", "operator": "AND", "boost": 2}}},
+			"filter": [
+				{"terms": {"keywords": ["Japan", "Tokyo"]}},
+				{"range": {"pub_date": {"gte": "2014-01-01", "lt": 1420070400000}}}
+			],
+			"should": {"match_phrase": {"abstract": "arrived in"}},
+			"must_not": {"term": {"is_published": {"value": false}}},
+			"minimum_should_match": 1
+		}},
+		"aggs": {"keywords": {"terms": {"field": "keywords"}}}
+	}`))
+	r.NoError(err)
+	r.Equal(&Request{
+		Query: &Bool{
+			Must: []Query{&Match{Field: "headline", Text: "obama japan", And: true, Boost: 2}},
+			Filter: []Query{
+				&Terms{Field: "keywords", Values: []interface{}{"Japan", "Tokyo"}},
+				&Range{Field: "pub_date", GTE: "2014-01-01", LT: 1420070400000.0},
+			},
+			Should:             []Query{&MatchPhrase{Field: "abstract", Text: "arrived in"}},
+			MustNot:            []Query{&Term{Field: "is_published", Value: false}},
+			MinimumShouldMatch: 1,
+		},
+		From: 5,
+		Size: 20,
+		Aggs: map[string]TermsAgg{"keywords": {Field: "keywords", Size: DefaultSize}},
+	}, req)
+
+	// Only the inner query of a function_score query is used.
+	q, err := os.ReadFile(mappingsDir + "query-with-scoring-function.json")
+	r.NoError(err)
+	req, err = Parse(q)
+	r.NoError(err)
+	r.IsType(&Bool{}, req.Query)
+
+	for q, msg := range map[string]string{
+		`{"query": {"fuzzy": {"headline": "presdent"}}}`:                                "unsupported query type `fuzzy`",
+		`{"query": {"match": {"headline": "a", "abstract": "b"}}}`:                      "expected a single field for match query",
+		`{"query": {"bool": {"must_nt": {"match_all": {}}}}}`:                           "unsupported bool clause `must_nt`",
+		`{"query": {"range": {"pub_date": {"gte": ["2014"]}}}}`:                         "expected a string or number",
+		`{"query": {"range": {"pub_date": {"gte": "2014", "format": "yyyy"}}}}`:         "unsupported format `yyyy`",
+		`{"query": {"range": {"pub_date": {"gte": "2014", "time_zone": "Mars/Base"}}}}`: "unsupported time_zone",
+		`{"aggs": {"avg_likes": {"avg": {"field": "num_likes"}}}}`:                      "only terms aggregations are supported",
+	} {
+		_, err := Parse([]byte(q))
+		r.ErrorContains(err, msg, q)
+	}
+}
+
+func TestRangeDate(t *testing.T) {
+	r := require.New(t)
+
+	date := func(q *Range, bound interface{}) string {
+		d, err := q.Date(bound)
+		r.NoError(err)
+		return d.Format(time.RFC3339)
+	}
+
+	q := &Range{}
+	r.Equal("2014-04-23T00:00:00Z", date(q, "2014-04-23"))
+	r.Equal("2014-04-01T00:00:00Z", date(q, "2014-04"))
+	r.Equal("2014-04-22T22:00:00Z", date(q, "2014-04-23T00:00:00+0200"))
+	r.Equal("2014-04-22T22:00:00Z", date(q, "2014-04-23T00:00:00+02:00"))
+	r.Equal("2014-04-23T00:00:00Z", date(q, 1398211200000.0))
+
+	// Time zones only apply to dates without an offset.
+	q = &Range{TimeZone: "+02:00"}
+	r.Equal("2014-04-22T22:00:00Z", date(q, "2014-04-23"))
+	r.Equal("2014-04-23T00:00:00Z", date(q, "2014-04-23T00:00:00Z"))
+
+	q = &Range{Format: "epoch_second"}
+	r.Equal("2014-04-23T00:00:00Z", date(q, "1398211200"))
+	r.Equal("2014-04-23T00:00:00Z", date(q, 1398211200.0))
+	_, err := q.Date("2014-04-23")
+	r.ErrorContains(err, "doesn't match format epoch_second")
+
+	q = &Range{Format: "strict_date_optional_time||epoch_millis"}
+	r.Equal("2014-04-23T00:00:00Z", date(q, "2014-04-23"))
+	r.Equal("2014-04-23T00:00:00Z", date(q, "1398211200000"))
+
+	_, err = (&Range{}).Date("now-1d")
+	r.ErrorContains(err, "unsupported date `now-1d`")
+
+	d, err := ParseDate("2014-04-23T02:00:00+02:00")
+	r.NoError(err)
+	r.Equal("2014-04-23T00:00:00Z", d.Format(time.RFC3339))
+
+	r.True((&Range{GT: "2014"}).IsDate())
+	r.True((&Range{GT: 1.0, Format: "epoch_millis"}).IsDate())
+	r.False((&Range{GT: 1.0}).IsDate())
+}
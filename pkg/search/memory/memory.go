@@ -0,0 +1,433 @@
+// Memory package implements an in-process search engine backend with an
+// inverted index and BM25 scoring. It's deterministic, needs no running
+// services and serves as a reference to sanity-check ES relevance and as a
+// fast backend for unit tests.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/stats"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// Gap between the positions of the values of a multi-valued text field,
+// so that phrases don't match across values (same as ES).
+const positionGap = 100
+
+// Memory holds any number of indexes in memory.
+type Memory struct {
+	// Boosts multiply the scores of matches in the given fields, e.g.
+	// {"headline": 2} makes headline matches count twice as much as
+	// abstract matches. Fields default to a boost of 1.
+	Boosts map[string]float64
+
+	mu      sync.RWMutex // Guards indexes.
+	indexes map[string]*index
+
+	stats domain.BulkStats
+}
+
+func New() *Memory {
+	return &Memory{indexes: make(map[string]*index)}
+}
+
+// DefaultMapping returns the field types of the NY Times index mappings in
+// assets/mappings, used for indexes created implicitly by BulkIndex.
+func DefaultMapping() map[string]string {
+	return map[string]string{
+		"id":             "keyword",
+		"headline":       "text",
+		"print_headline": "text",
+		"abstract":       "text",
+		"lead_paragraph": "text",
+		"is_published":   "boolean",
+		"keywords":       "keyword",
+		"pub_date":       "date",
+		"num_likes":      "integer",
+		"num_comments":   "integer",
+		"multimedia":     "flattened",
+	}
+}
+
+// index is an inverted index over the text and keyword fields of its
+// docs, plus the docs themselves for filtering.
+type index struct {
+	mapping map[string]string // Field types by field name.
+
+	docs     []*doc         // By doc number, nil once deleted.
+	byID     map[string]int // Doc numbers by ID.
+	live     int            // Number of docs not deleted.
+	texts    map[string]*textField
+	keywords map[string]*keywordField
+}
+
+type doc struct {
+	id       string
+	source   map[string]interface{}
+	terms    map[string][]string // Unique terms by text field.
+	keywords map[string][]string // Unique values by keyword field.
+}
+
+type textField struct {
+	postings map[string][]posting // Sorted by doc number.
+	lengths  map[int]int          // Field length in terms by doc number.
+	totalLen int
+}
+
+type posting struct {
+	doc       int
+	positions []int
+}
+
+// keywordField indexes the values of a keyword field as is. Keywords within
+// flattened and object fields aren't indexed.
+type keywordField struct {
+	postings map[string]map[int]bool // Doc numbers by value.
+	numDocs  int                     // Number of docs with a value.
+}
+
+func newIndex(mapping map[string]string) *index {
+	idx := &index{
+		mapping:  mapping,
+		byID:     make(map[string]int),
+		texts:    make(map[string]*textField),
+		keywords: make(map[string]*keywordField),
+	}
+
+	for field, typ := range mapping {
+		switch typ {
+		case "text":
+			idx.texts[field] = &textField{
+				postings: make(map[string][]posting),
+				lengths:  make(map[int]int),
+			}
+		case "keyword":
+			idx.keywords[field] = &keywordField{postings: make(map[string]map[int]bool)}
+		}
+	}
+
+	return idx
+}
+
+// CreateIndex creates an index with the field types in an ES index
+// mappings file, dropping any existing index with the same name first.
+func (m *Memory) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error {
+	data, err := os.ReadFile(mappingsJSONFile)
+	if err != nil {
+		return errors.Wrap(err, "could not read mappings")
+	}
+
+	var em struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+
+	err = json.Unmarshal(data, &em)
+	if err != nil {
+		return errors.Wrapf(err, "could not decode mappings in %s", mappingsJSONFile)
+	}
+
+	mapping := make(map[string]string)
+	for field, p := range em.Mappings.Properties {
+		// Objects with properties have no type.
+		if p.Type == "" {
+			p.Type = "object"
+		}
+		mapping[field] = p.Type
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.indexes[indexName] = newIndex(mapping)
+
+	return nil
+}
+
+func (m *Memory) DeleteIndex(ctx context.Context, indexName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.indexes, indexName)
+
+	return nil
+}
+
+// BulkIndex adds docs to an index, replacing any existing docs with the
+// same IDs. The index is created with DefaultMapping if it doesn't exist.
+// Docs that can't be converted to JSON objects are listed in the result
+// and not indexed.
+func (m *Memory) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	if err := domain.CheckBulk(docIDs, docs); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	timer := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx, ok := m.indexes[indexName]
+	if !ok {
+		idx = newIndex(DefaultMapping())
+		m.indexes[indexName] = idx
+	}
+
+	result := new(domain.BulkResult)
+
+	for i, id := range docIDs {
+		var source map[string]interface{}
+
+		err := domain.DecodeDoc(docs[i], &source)
+		if err != nil {
+			result.Failures = append(result.Failures, domain.InvalidDoc(i, id, err))
+			continue
+		}
+
+		idx.add(id, source)
+		result.Indexed++
+	}
+
+	m.stats.Add(result.Indexed, time.Since(timer))
+
+	return result, nil
+}
+
+// add indexes a doc, replacing any existing doc with the same ID.
+func (idx *index) add(id string, source map[string]interface{}) {
+	idx.remove(id)
+
+	n := len(idx.docs)
+	d := &doc{id: id, source: source, terms: make(map[string][]string), keywords: make(map[string][]string)}
+
+	for field, tf := range idx.texts {
+		positions := make(map[string][]int)
+		var unique []string
+		var pos int
+
+		for _, v := range values(source, field) {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+
+			if pos > 0 {
+				pos += positionGap
+			}
+
+			for _, term := range stats.Tokenize(s) {
+				if _, ok := positions[term]; !ok {
+					unique = append(unique, term)
+				}
+				positions[term] = append(positions[term], pos)
+				pos++
+			}
+		}
+
+		if len(unique) == 0 {
+			continue
+		}
+
+		for _, term := range unique {
+			tf.postings[term] = append(tf.postings[term], posting{doc: n, positions: positions[term]})
+		}
+
+		length := 0
+		for _, p := range positions {
+			length += len(p)
+		}
+
+		tf.lengths[n] = length
+		tf.totalLen += length
+		d.terms[field] = unique
+	}
+
+	for field, kf := range idx.keywords {
+		var unique []string
+
+		for _, v := range values(source, field) {
+			value := fmt.Sprint(v)
+			if kf.postings[value][n] {
+				continue
+			}
+
+			if kf.postings[value] == nil {
+				kf.postings[value] = make(map[int]bool)
+			}
+			kf.postings[value][n] = true
+			unique = append(unique, value)
+		}
+
+		if len(unique) > 0 {
+			kf.numDocs++
+			d.keywords[field] = unique
+		}
+	}
+
+	idx.docs = append(idx.docs, d)
+	idx.byID[id] = n
+	idx.live++
+}
+
+// remove removes a doc from the index, if it exists.
+func (idx *index) remove(id string) {
+	n, ok := idx.byID[id]
+	if !ok {
+		return
+	}
+
+	d := idx.docs[n]
+
+	for field, terms := range d.terms {
+		tf := idx.texts[field]
+
+		for _, term := range terms {
+			ps := tf.postings[term]
+
+			i := sort.Search(len(ps), func(i int) bool { return ps[i].doc >= n })
+			if i < len(ps) && ps[i].doc == n {
+				ps = append(ps[:i], ps[i+1:]...)
+			}
+
+			if len(ps) == 0 {
+				delete(tf.postings, term)
+			} else {
+				tf.postings[term] = ps
+			}
+		}
+
+		tf.totalLen -= tf.lengths[n]
+		delete(tf.lengths, n)
+	}
+
+	for field, values := range d.keywords {
+		kf := idx.keywords[field]
+
+		for _, value := range values {
+			delete(kf.postings[value], n)
+			if len(kf.postings[value]) == 0 {
+				delete(kf.postings, value)
+			}
+		}
+
+		kf.numDocs--
+	}
+
+	idx.docs[n] = nil
+	delete(idx.byID, id)
+	idx.live--
+}
+
+// values returns the values at a dotted path in a doc, flattening arrays.
+func values(source map[string]interface{}, path string) []interface{} {
+	vs := []interface{}{source}
+
+	for _, key := range splitPath(path) {
+		var next []interface{}
+
+		for _, v := range vs {
+			if obj, ok := v.(map[string]interface{}); ok {
+				next = append(next, flatten(obj[key])...)
+			}
+		}
+
+		vs = next
+	}
+
+	return vs
+}
+
+func flatten(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var vs []interface{}
+		for _, item := range v {
+			vs = append(vs, flatten(item)...)
+		}
+		return vs
+	default:
+		return []interface{}{v}
+	}
+}
+
+func splitPath(path string) []string {
+	var keys []string
+
+	start := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] == '.' {
+			keys = append(keys, path[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(keys, path[start:])
+}
+
+func (m *Memory) Refresh(ctx context.Context, indexName string) error {
+	_, err := m.Count(ctx, indexName)
+	return err
+}
+
+func (m *Memory) Count(ctx context.Context, indexName string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idx, ok := m.indexes[indexName]
+	if !ok {
+		return 0, errors.Errorf("no such index %s", indexName)
+	}
+
+	return idx.live, nil
+}
+
+// WordCounts returns the number of times each term occurs in a text field
+// across all docs, e.g. for use with datagen.NewWordDistribution.
+func (m *Memory) WordCounts(indexName, field string) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idx, ok := m.indexes[indexName]
+	if !ok {
+		return nil, errors.Errorf("no such index %s", indexName)
+	}
+
+	tf, ok := idx.texts[field]
+	if !ok {
+		return nil, errors.Errorf("%s is not a text field", field)
+	}
+
+	counts := make(map[string]int64, len(tf.postings))
+	for term, ps := range tf.postings {
+		for _, p := range ps {
+			counts[term] += int64(len(p.positions))
+		}
+	}
+
+	return counts, nil
+}
+
+func (m *Memory) PrintBulkIndexingRate() {
+	m.stats.Print()
+}
+
+// Close is a no-op.
+func (m *Memory) Close() error {
+	return nil
+}
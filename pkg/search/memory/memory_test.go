@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/datagen"
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/search/esquery"
+	"github.com/anrid/nytimes/pkg/search/searchtest"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := New()
+	defer s.Close()
+
+	searchtest.Run(t, s)
+
+	// The shortest headline mentioning the president ranks first.
+	q, err := os.ReadFile(searchtest.MappingsDir + "query-simple.json")
+	r.NoError(err)
+	sr, err := s.Search(ctx, searchtest.IndexName, q)
+	r.NoError(err)
+	r.Equal([]string{"4", "1", "2"}, searchtest.HitIDs(sr))
+
+	_, err = s.Search(ctx, searchtest.IndexName, []byte(`{"query": {"term": {"byline": "x"}}}`))
+	r.ErrorContains(err, "unknown field byline")
+	_, err = s.Search(ctx, searchtest.IndexName, []byte(`{"aggs": {"headlines": {"terms": {"field": "headline"}}}}`))
+	r.ErrorContains(err, "terms aggregations are only supported on keyword fields, headline is a text field")
+
+	r.NoError(s.DeleteIndex(ctx, searchtest.IndexName))
+	_, err = s.Count(ctx, searchtest.IndexName)
+	r.ErrorContains(err, "no such index test")
+}
+
+func TestMemoryRelevance(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := New()
+
+	// Indexes are created with the default mapping on first use.
+	res, err := s.BulkIndex(ctx, "test",
+		[]string{"a", "b", "c", "d"},
+		[]interface{}{
+			&domain.SearchArticle{ID: "a", Headline: "Budget talks stall", Abstract: "Talks on the city budget stall again.", Keywords: []string{"Budget"}},
+			&domain.SearchArticle{ID: "b", Headline: "A long day of talks about many things other than money", Abstract: "The budget was mentioned once.", Keywords: []string{"Politics"}},
+			&domain.SearchArticle{ID: "c", Headline: "Budget budget budget", Keywords: []string{"Budget", "Politics"}},
+			&domain.SearchArticle{ID: "d", Headline: "Weather", Abstract: "Sunny with a chance of budget cuts."},
+		},
+	)
+	r.NoError(err)
+	r.Equal(4, res.Indexed)
+
+	run := func(q esquery.Query) []domain.SearchHit {
+		sr, err := s.Execute(ctx, "test", &esquery.Request{Query: q, Size: 10})
+		r.NoError(err)
+		return sr.Hits
+	}
+	hitIDs := func(hits []domain.SearchHit) []string {
+		var ids []string
+		for _, h := range hits {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// BM25 favours repeated terms, with diminishing returns, and short fields.
+	hits := run(&esquery.Match{Field: "headline", Text: "budget"})
+	r.Equal([]string{"c", "a"}, hitIDs(hits))
+	r.Greater(hits[0].Score, hits[1].Score)
+	r.Less(hits[0].Score, 3*hits[1].Score)
+
+	// Rare terms weigh more than common ones.
+	hits = run(&esquery.Match{Field: "headline", Text: "talks weather"})
+	r.Equal("d", hits[0].ID)
+
+	// Field boosts change which field matters most.
+	q := &esquery.Bool{Should: []esquery.Query{
+		&esquery.Match{Field: "headline", Text: "talks"},
+		&esquery.Match{Field: "abstract", Text: "sunny"},
+	}}
+	r.Equal([]string{"d", "a", "b"}, hitIDs(run(q)))
+
+	s.Boosts = map[string]float64{"headline": 10}
+	r.Equal([]string{"a", "b", "d"}, hitIDs(run(q)))
+	s.Boosts = nil
+
+	// Query boosts too.
+	q.Should[1].(*esquery.Match).Boost = 0.01
+	r.Equal([]string{"a", "b", "d"}, hitIDs(run(q)))
+
+	// Phrases match consecutive terms only.
+	r.Equal([]string{"a"}, hitIDs(run(&esquery.MatchPhrase{Field: "abstract", Text: "city budget stall"})))
+	r.Equal([]string{"d"}, hitIDs(run(&esquery.MatchPhrase{Field: "abstract", Text: "budget cuts"})))
+	r.Empty(run(&esquery.MatchPhrase{Field: "abstract", Text: "budget stall city"}))
+
+	// Keyword filters don't affect scores.
+	all := run(&esquery.Match{Field: "abstract", Text: "budget"})
+	filtered := run(&esquery.Bool{
+		Must:   []esquery.Query{&esquery.Match{Field: "abstract", Text: "budget"}},
+		Filter: []esquery.Query{&esquery.Terms{Field: "keywords", Values: []interface{}{"Budget", "Politics"}}},
+	})
+	r.Equal([]string{"b", "a"}, hitIDs(filtered))
+	for _, h := range filtered {
+		for _, h2 := range all {
+			if h.ID == h2.ID {
+				r.Equal(h2.Score, h.Score)
+			}
+		}
+	}
+
+	// Keywords are matched exactly.
+	r.Empty(run(&esquery.Term{Field: "keywords", Value: "budget"}))
+	r.ElementsMatch([]string{"a", "c"}, hitIDs(run(&esquery.Term{Field: "keywords", Value: "Budget"})))
+	r.Equal([]string{"d"}, hitIDs(run(&esquery.Bool{MustNot: []esquery.Query{&esquery.Term{Field: "headline", Value: "budget"}}, Filter: []esquery.Query{&esquery.Match{Field: "abstract", Text: "cuts"}}})))
+
+	// Phrases don't match across the values of multi-valued fields.
+	_, err = s.BulkIndex(ctx, "test", []string{"e"}, []interface{}{json.RawMessage(`{"id": "e", "abstract": ["More budget", "cuts ahead"]}`)})
+	r.NoError(err)
+	r.Equal([]string{"d"}, hitIDs(run(&esquery.MatchPhrase{Field: "abstract", Text: "budget cuts"})))
+	r.ElementsMatch([]string{"d", "e"}, hitIDs(run(&esquery.Match{Field: "abstract", Text: "cuts"})))
+}
+
+func TestMemoryRandomQueries(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := New()
+
+	headlines := []string{
+		"President Obama Visits Japan",
+		"President Signs Budget Bill",
+		"Markets Rally in Tokyo",
+		"Senate Passes Budget After Long Debate",
+		"Tokyo Markets Fall as Yen Rises",
+		"Obama and Abe Discuss Trade",
+	}
+
+	var ids []string
+	var docs []interface{}
+	for i, h := range headlines {
+		ids = append(ids, string(rune('a'+i)))
+		docs = append(docs, &domain.SearchArticle{ID: ids[i], Headline: h})
+	}
+
+	_, err := s.BulkIndex(ctx, "test", ids, docs)
+	r.NoError(err)
+
+	counts, err := s.WordCounts("test", "headline")
+	r.NoError(err)
+	r.Equal(int64(2), counts["president"])
+	r.Equal(int64(2), counts["budget"])
+
+	_, err = s.WordCounts("test", "keywords")
+	r.ErrorContains(err, "keywords is not a text field")
+
+	// Random words drawn from the index itself always find something, and
+	// the same query always gets the same results.
+	wd := datagen.NewWordDistribution(counts)
+
+	for i := 0; i < 100; i++ {
+		word := wd.RandomWord()
+		q := &esquery.Match{Field: "headline", Text: word}
+
+		sr, err := s.Execute(ctx, "test", &esquery.Request{Query: q, Size: 10})
+		r.NoError(err)
+		r.NotEmpty(sr.Hits, word)
+
+		for _, h := range sr.Hits {
+			r.Contains(strings.ToLower(h.Source["headline"].(string)), word)
+		}
+
+		again, err := s.Execute(ctx, "test", &esquery.Request{Query: q, Size: 10})
+		r.NoError(err)
+		r.Equal(sr, again)
+	}
+}
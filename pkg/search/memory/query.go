@@ -0,0 +1,715 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/search/esquery"
+	"github.com/anrid/nytimes/pkg/stats"
+	"github.com/pkg/errors"
+)
+
+// BM25 parameters, same as the ES defaults.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Layouts accepted for date values.
+var dateLayouts = []string{"2006-01-02T15:04:05-0700", time.RFC3339Nano, "2006-01-02"}
+
+type matches map[int]float64
+
+// Search runs an ES style JSON search request, see esquery.Parse.
+func (m *Memory) Search(ctx context.Context, indexName string, queryJSON []byte) (*domain.SearchResult, error) {
+	req, err := esquery.Parse(queryJSON)
+	if err != nil {
+		return nil, err
+	}
+	return m.Execute(ctx, indexName, req)
+}
+
+// Execute runs a search request, which can also be built in Go. Hits are
+// sorted by score, then by doc ID, so results are always the same for the
+// same docs and request. Scores are like ES's:
+//
+//   - match, match_phrase and term queries on text fields are scored with
+//     BM25, phrases using the number of times the phrase occurs
+//   - term queries on keyword fields are scored by how rare the keyword is
+//   - any other queries get a constant score
+//
+// Terms in term queries aren't analyzed, so terms in text fields must be
+// lowercase words, like in ES.
+func (m *Memory) Execute(ctx context.Context, indexName string, req *esquery.Request) (*domain.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idx, ok := m.indexes[indexName]
+	if !ok {
+		return nil, errors.Errorf("no such index %s", indexName)
+	}
+
+	s := &searcher{idx: idx, boosts: m.Boosts}
+
+	q := req.Query
+	if q == nil {
+		q = &esquery.MatchAll{}
+	}
+
+	found, err := s.eval(q)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &domain.SearchResult{Total: len(found)}
+
+	hits := make([]domain.SearchHit, 0, len(found))
+	for n, score := range found {
+		hits = append(hits, domain.SearchHit{ID: idx.docs[n].id, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	if req.From < len(hits) {
+		hits = hits[req.From:]
+	} else {
+		hits = nil
+	}
+	if req.Size < len(hits) {
+		hits = hits[:req.Size]
+	}
+
+	for i := range hits {
+		source := idx.docs[idx.byID[hits[i].ID]].source
+
+		hits[i].Source = make(map[string]interface{}, len(source))
+		for k, v := range source {
+			hits[i].Source[k] = v
+		}
+	}
+
+	sr.Hits = hits
+
+	for name, agg := range req.Aggs {
+		if sr.Facets == nil {
+			sr.Facets = make(map[string][]domain.FacetTerm)
+		}
+		sr.Facets[name], err = s.facet(found, agg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid aggregation %s", name)
+		}
+	}
+
+	return sr, nil
+}
+
+// facet returns the most common values of a keyword field in the given
+// docs.
+func (s *searcher) facet(found matches, agg esquery.TermsAgg) ([]domain.FacetTerm, error) {
+	typ, err := s.fieldType(agg.Field)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "keyword" {
+		return nil, errors.Errorf("terms aggregations are only supported on keyword fields, %s is a %s field", agg.Field, typ)
+	}
+
+	counts := make(map[string]int)
+
+	for n := range found {
+		seen := make(map[string]bool)
+
+		for _, v := range values(s.idx.docs[n].source, agg.Field) {
+			term := fmt.Sprint(v)
+			if !seen[term] {
+				seen[term] = true
+				counts[term]++
+			}
+		}
+	}
+
+	terms := []domain.FacetTerm{}
+	for term, count := range counts {
+		terms = append(terms, domain.FacetTerm{Term: term, Count: count})
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+
+	size := agg.Size
+	if size == 0 {
+		size = esquery.DefaultSize
+	}
+	if size < len(terms) {
+		terms = terms[:size]
+	}
+
+	return terms, nil
+}
+
+// searcher evaluates queries against an index.
+type searcher struct {
+	idx    *index
+	boosts map[string]float64
+}
+
+func (s *searcher) fieldBoost(field string) float64 {
+	if b, ok := s.boosts[field]; ok {
+		return b
+	}
+	return 1
+}
+
+// fieldType returns the type of a field. Fields within flattened and object
+// fields are keywords.
+func (s *searcher) fieldType(field string) (string, error) {
+	if typ, ok := s.idx.mapping[field]; ok {
+		return typ, nil
+	}
+
+	if i := strings.IndexByte(field, '.'); i > 0 {
+		switch s.idx.mapping[field[:i]] {
+		case "flattened", "object":
+			return "keyword", nil
+		}
+	}
+
+	return "", errors.Errorf("unknown field %s", field)
+}
+
+// all returns all docs with the given score.
+func (s *searcher) all(score float64) matches {
+	found := make(matches, s.idx.live)
+	for n, d := range s.idx.docs {
+		if d != nil {
+			found[n] = score
+		}
+	}
+	return found
+}
+
+// scan returns all docs with a value in a field for which match returns
+// true, with a score of 0.
+func (s *searcher) scan(field string, match func(v interface{}) bool) matches {
+	found := make(matches)
+
+	for n, d := range s.idx.docs {
+		if d == nil {
+			continue
+		}
+
+		for _, v := range values(d.source, field) {
+			if match(v) {
+				found[n] = 0
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// terms returns the docs containing any, or all, of the given terms in a
+// text field, scored with BM25.
+func (s *searcher) terms(field string, terms []string, all bool, boost float64) matches {
+	tf := s.idx.texts[field]
+	found := make(matches)
+
+	numDocs := len(tf.lengths)
+	if numDocs == 0 {
+		return found
+	}
+	avgLen := float64(tf.totalLen) / float64(numDocs)
+
+	terms = unique(terms)
+	numTerms := make(map[int]int)
+
+	for _, t := range terms {
+		ps := tf.postings[t]
+		w := idf(numDocs, len(ps))
+
+		for _, p := range ps {
+			found[p.doc] += w * bm25(len(p.positions), tf.lengths[p.doc], avgLen)
+			numTerms[p.doc]++
+		}
+	}
+
+	for n := range found {
+		if all && numTerms[n] < len(terms) {
+			delete(found, n)
+			continue
+		}
+		found[n] *= boost * s.fieldBoost(field)
+	}
+
+	return found
+}
+
+// idf is the inverse document frequency of a term found in df out of
+// numDocs docs, as computed by Lucene.
+func idf(numDocs, df int) float64 {
+	return math.Log(1 + (float64(numDocs)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// bm25 is the BM25 term frequency weight of a term occurring freq times in
+// a field of length fieldLen, as computed by Lucene.
+func bm25(freq, fieldLen int, avgLen float64) float64 {
+	f := float64(freq)
+	return f / (f + k1*(1-b+b*float64(fieldLen)/avgLen))
+}
+
+func unique(terms []string) []string {
+	var u []string
+	seen := make(map[string]bool)
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			u = append(u, t)
+		}
+	}
+	return u
+}
+
+func boostOr1(boost float64) float64 {
+	if boost == 0 {
+		return 1
+	}
+	return boost
+}
+
+func constant(found matches, score float64) matches {
+	for n := range found {
+		found[n] = score
+	}
+	return found
+}
+
+// eval returns the scores of all docs matching a query, by doc number.
+func (s *searcher) eval(q esquery.Query) (matches, error) {
+	switch q := q.(type) {
+	case *esquery.MatchAll:
+		return s.all(boostOr1(q.Boost)), nil
+	case *esquery.Match:
+		return s.match(q)
+	case *esquery.MatchPhrase:
+		return s.phrase(q)
+	case *esquery.Term:
+		return s.term(q)
+	case *esquery.Terms:
+		return s.termsQuery(q)
+	case *esquery.Range:
+		return s.rangeQuery(q)
+	case *esquery.Bool:
+		return s.boolQuery(q)
+	case *esquery.ConstantScore:
+		found, err := s.eval(q.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return constant(found, boostOr1(q.Boost)), nil
+	default:
+		return nil, errors.Errorf("unsupported query %T", q)
+	}
+}
+
+func (s *searcher) term(q *esquery.Term) (matches, error) {
+	typ, err := s.fieldType(q.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ == "text" {
+		term, ok := q.Value.(string)
+		if !ok {
+			return nil, errors.Errorf("expected a string term for text field %s", q.Field)
+		}
+		return s.terms(q.Field, []string{term}, false, boostOr1(q.Boost)), nil
+	}
+
+	found := make(matches)
+	numDocs := 0 // Number of docs with a value in the field.
+
+	if kf, ok := s.idx.keywords[q.Field]; ok {
+		for n := range kf.postings[fmt.Sprint(q.Value)] {
+			found[n] = 0
+		}
+		numDocs = kf.numDocs
+	} else {
+		for n, d := range s.idx.docs {
+			if d == nil {
+				continue
+			}
+
+			vs := values(d.source, q.Field)
+			if len(vs) > 0 {
+				numDocs++
+			}
+
+			for _, v := range vs {
+				if c, ok := compare(typ, v, q.Value); ok && c == 0 {
+					found[n] = 0
+					break
+				}
+			}
+		}
+	}
+
+	score := boostOr1(q.Boost)
+	if typ == "keyword" {
+		// Keywords have no length, so BM25 comes down to the idf.
+		score *= s.fieldBoost(q.Field) * idf(numDocs, len(found)) / (1 + k1)
+	}
+
+	return constant(found, score), nil
+}
+
+// termsQuery matches docs with any of the values, with a constant score.
+func (s *searcher) termsQuery(q *esquery.Terms) (matches, error) {
+	found := make(matches)
+
+	for _, v := range q.Values {
+		m, err := s.term(&esquery.Term{Field: q.Field, Value: v})
+		if err != nil {
+			return nil, err
+		}
+
+		for n := range m {
+			found[n] = 0
+		}
+	}
+
+	return constant(found, boostOr1(q.Boost)), nil
+}
+
+// match is the same as a term query for Text on fields other than text
+// fields.
+func (s *searcher) match(q *esquery.Match) (matches, error) {
+	typ, err := s.fieldType(q.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != "text" {
+		return s.term(&esquery.Term{Field: q.Field, Value: q.Text, Boost: q.Boost})
+	}
+
+	return s.terms(q.Field, stats.Tokenize(q.Text), q.And, boostOr1(q.Boost)), nil
+}
+
+func (s *searcher) phrase(q *esquery.MatchPhrase) (matches, error) {
+	typ, err := s.fieldType(q.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != "text" {
+		return s.term(&esquery.Term{Field: q.Field, Value: q.Text, Boost: q.Boost})
+	}
+
+	terms := stats.Tokenize(q.Text)
+	if len(terms) < 2 {
+		return s.terms(q.Field, terms, true, boostOr1(q.Boost)), nil
+	}
+
+	tf := s.idx.texts[q.Field]
+	numDocs := len(tf.lengths)
+	found := make(matches)
+
+	// Positions of each term by doc number, and the summed idf of all
+	// terms, which is how Lucene weighs phrases.
+	var positions []map[int][]int
+	var w float64
+
+	for _, t := range terms {
+		ps := tf.postings[t]
+		if len(ps) == 0 {
+			return found, nil
+		}
+
+		byDoc := make(map[int][]int, len(ps))
+		for _, p := range ps {
+			byDoc[p.doc] = p.positions
+		}
+
+		positions = append(positions, byDoc)
+		w += idf(numDocs, len(ps))
+	}
+
+	avgLen := float64(tf.totalLen) / float64(numDocs)
+	boost := boostOr1(q.Boost) * s.fieldBoost(q.Field)
+
+	for n, first := range positions[0] {
+		freq := 0
+
+	next:
+		for _, pos := range first {
+			for i := 1; i < len(terms); i++ {
+				ps := positions[i][n]
+				j := sort.SearchInts(ps, pos+i)
+				if j == len(ps) || ps[j] != pos+i {
+					continue next
+				}
+			}
+			freq++
+		}
+
+		if freq > 0 {
+			found[n] = boost * w * bm25(freq, tf.lengths[n], avgLen)
+		}
+	}
+
+	return found, nil
+}
+
+// rangeQuery matches docs within the bounds with a constant score. Date
+// fields take date strings or epoch millis as bounds.
+func (s *searcher) rangeQuery(q *esquery.Range) (matches, error) {
+	typ, err := s.fieldType(q.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each bound along with the comparison results it allows.
+	bounds := []struct {
+		value interface{}
+		ok    func(c int) bool
+	}{
+		{q.GT, func(c int) bool { return c > 0 }},
+		{q.GTE, func(c int) bool { return c >= 0 }},
+		{q.LT, func(c int) bool { return c < 0 }},
+		{q.LTE, func(c int) bool { return c <= 0 }},
+	}
+
+	if typ == "date" {
+		for i, bound := range bounds {
+			if bound.value == nil {
+				continue
+			}
+
+			t, err := q.Date(bound.value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid range on field %s", q.Field)
+			}
+			bounds[i].value = t
+		}
+	}
+
+	found := s.scan(q.Field, func(v interface{}) bool {
+		for _, bound := range bounds {
+			if bound.value == nil {
+				continue
+			}
+			c, ok := compare(typ, v, bound.value)
+			if !ok || !bound.ok(c) {
+				return false
+			}
+		}
+		return true
+	})
+
+	return constant(found, boostOr1(q.Boost)), nil
+}
+
+// boolQuery scores docs by the sum of the scores of their matching Must
+// and Should queries. If there are no Must or Filter queries,
+// MinimumShouldMatch defaults to 1.
+func (s *searcher) boolQuery(q *esquery.Bool) (matches, error) {
+	var found matches
+
+	// Narrow down to the docs matching all required queries.
+	for i, sub := range append(append([]esquery.Query(nil), q.Must...), q.Filter...) {
+		m, err := s.eval(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		// Filters don't count towards the score.
+		if i >= len(q.Must) {
+			constant(m, 0)
+		}
+
+		if found == nil {
+			found = m
+			continue
+		}
+
+		for n := range found {
+			score, ok := m[n]
+			if !ok {
+				delete(found, n)
+				continue
+			}
+			found[n] += score
+		}
+	}
+
+	minShould := q.MinimumShouldMatch
+	if found == nil {
+		found = s.all(0)
+
+		if minShould == 0 && len(q.Should) > 0 {
+			minShould = 1
+		}
+	}
+
+	numShould := make(map[int]int)
+
+	for _, sub := range q.Should {
+		m, err := s.eval(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		for n, score := range m {
+			if _, ok := found[n]; ok {
+				found[n] += score
+				numShould[n]++
+			}
+		}
+	}
+
+	for _, sub := range q.MustNot {
+		m, err := s.eval(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		for n := range m {
+			delete(found, n)
+		}
+	}
+
+	boost := boostOr1(q.Boost)
+
+	for n := range found {
+		if numShould[n] < minShould {
+			delete(found, n)
+			continue
+		}
+		found[n] *= boost
+	}
+
+	return found, nil
+}
+
+// compare compares a doc value to a query value of a field of the given
+// type, returning false if they can't be compared.
+func compare(typ string, a, b interface{}) (int, bool) {
+	if typ == "date" {
+		ta, ok := toTime(a)
+		if !ok {
+			return 0, false
+		}
+		tb, ok := toTime(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case y:
+			return -1, true
+		default:
+			return 1, true
+		}
+
+	default:
+		fa, ok := toFloat(a)
+		if !ok {
+			return 0, false
+		}
+		fb, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// toTime converts a date string or epoch millis to a time.
+func toTime(v interface{}) (time.Time, bool) {
+	if t, ok := v.(time.Time); ok {
+		return t, true
+	}
+	if s, ok := v.(string); ok {
+		for _, layout := range dateLayouts {
+			t, err := time.Parse(layout, s)
+			if err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	ms, ok := toFloat(v)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.UnixMilli(int64(ms)), true
+}
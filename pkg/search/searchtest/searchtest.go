@@ -0,0 +1,187 @@
+// Searchtest package checks that search engine backends index articles and
+// run the ES style queries in assets/mappings the way ES does.
+package searchtest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/require"
+)
+
+// MappingsDir is the directory with the NY Times mappings and queries,
+// relative to the backend packages in pkg/search.
+const MappingsDir = "../../../assets/mappings/nytimes/"
+
+// IndexName is the name of the index created by Run.
+const IndexName = "test"
+
+// Engine is a search engine backend under test.
+type Engine interface {
+	domain.Indexer
+	domain.Searcher
+}
+
+// Run creates an index, loads a few articles into it and checks the
+// results of searching them. The index is left in place with 4 docs, so
+// that backends can go on to check e.g. reopening it.
+func Run(t *testing.T, e Engine) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	r.NoError(e.CreateIndex(ctx, MappingsDir+"index-mappings.json", IndexName))
+
+	docs := []*domain.SearchArticle{
+		{
+			ID:          "1",
+			Headline:    "President Obama Visits Japan",
+			Abstract:    "The president arrived in Tokyo.",
+			Keywords:    []string{"Obama, Barack", "Japan"},
+			PubDate:     "2014-04-23T00:00:00+0000",
+			NumLikes:    10,
+			IsPublished: true,
+			Multimedia:  []domain.Multimedia{{URL: "a.jpg", SubType: "thumbnail"}},
+		},
+		{
+			ID:          "2",
+			Headline:    "President Signs Budget Bill",
+			Keywords:    []string{"Obama, Barack"},
+			PubDate:     "2015-01-10T00:00:00+0000",
+			NumLikes:    50,
+			IsPublished: true,
+			Multimedia:  []domain.Multimedia{{URL: "b.jpg", SubType: "xlarge"}},
+		},
+		{
+			ID:       "3",
+			Headline: "Markets Rally in Tokyo",
+			Keywords: []string{"Japan"},
+			PubDate:  "2015-02-01T00:00:00+0000",
+			NumLikes: 5,
+		},
+	}
+
+	var ids []string
+	var batch []interface{}
+	for _, d := range docs {
+		ids = append(ids, d.ID)
+		batch = append(batch, d)
+	}
+
+	res, err := e.BulkIndex(ctx, IndexName, ids, batch)
+	r.NoError(err)
+	r.Equal(3, res.Indexed)
+	r.Empty(res.Failures)
+
+	// Docs replayed from a dead-letter file are raw JSON, which may not be
+	// an article at all.
+	raw, err := json.Marshal(&domain.SearchArticle{ID: "4", Headline: "Replayed President", PubDate: "2016-01-01T00:00:00+0000"})
+	r.NoError(err)
+
+	res, err = e.BulkIndex(ctx, IndexName, []string{"4", "5"}, []interface{}{json.RawMessage(raw), json.RawMessage(`[1]`)})
+	r.NoError(err)
+	r.Equal(1, res.Indexed)
+	r.Len(res.Failures, 1)
+	r.Equal(1, res.Failures[0].Pos)
+	r.Equal("5", res.Failures[0].DocID)
+
+	r.NoError(e.Refresh(ctx, IndexName))
+
+	n, err := e.Count(ctx, IndexName)
+	r.NoError(err)
+	r.Equal(4, n)
+
+	search := func(q string) *domain.SearchResult {
+		sr, err := e.Search(ctx, IndexName, []byte(q))
+		r.NoError(err, q)
+		return sr
+	}
+	searchFile := func(name string) *domain.SearchResult {
+		q, err := os.ReadFile(MappingsDir + name)
+		r.NoError(err)
+		return search(string(q))
+	}
+
+	// The queries used to benchmark ES work as is.
+	sr := searchFile("query-simple.json")
+	r.Equal(3, sr.Total)
+	r.ElementsMatch([]string{"1", "2", "4"}, HitIDs(sr))
+
+	sr = searchFile("query-complete-1.json")
+	r.Equal([]string{"1"}, HitIDs(sr))
+	r.Contains(sr.Facets["keywords"], domain.FacetTerm{Term: "Obama, Barack", Count: 1})
+	r.Equal("President Obama Visits Japan", sr.Hits[0].Source["headline"])
+
+	sr = searchFile("query-with-scoring-function.json")
+	r.Equal(3, sr.Total)
+
+	// Matches, phrases and keywords.
+	r.Equal([]string{"1"}, HitIDs(search(`{"query": {"match": {"headline": {"query": "president japan", "operator": "and"}}}}`)))
+	r.Equal([]string{"2"}, HitIDs(search(`{"query": {"match_phrase": {"headline": "signs budget"}}}`)))
+	r.Empty(HitIDs(search(`{"query": {"match_phrase": {"headline": "budget signs"}}}`)))
+	r.ElementsMatch([]string{"1", "2", "3"}, HitIDs(search(`{"query": {"terms": {"keywords": ["Japan", "Obama, Barack"]}}}`)))
+	r.Equal([]string{"1"}, HitIDs(search(`{"query": {"term": {"multimedia.subType": "thumbnail"}}}`)))
+
+	// Ranges, with dates in any supported format and time zone.
+	r.ElementsMatch([]string{"1", "2"}, HitIDs(search(`{"query": {"range": {"num_likes": {"gte": 10}}}}`)))
+	r.ElementsMatch([]string{"2", "3"}, HitIDs(search(`{"query": {"range": {"pub_date": {"gte": "2015-01-01", "lt": "2016-01-01"}}}}`)))
+	r.ElementsMatch([]string{"2", "3"}, HitIDs(search(`{"query": {"range": {"pub_date": {"gte": 1420070400000, "lt": "2016-01-01T00:00:00Z"}}}}`)))
+	r.ElementsMatch([]string{"2", "3", "4"}, HitIDs(search(`{"query": {"range": {"pub_date": {"gte": 1420070400000, "format": "epoch_millis"}}}}`)))
+	r.ElementsMatch([]string{"3", "4"}, HitIDs(search(`{"query": {"range": {"pub_date": {"gte": "1422748800", "format": "epoch_second"}}}}`)))
+	r.Equal([]string{"2"}, HitIDs(search(`{"query": {"range": {"pub_date": {"gte": "2015-01-10T02:00:00", "lte": "2015-01-10T02:00:00", "time_zone": "+02:00"}}}}`)))
+
+	// Bool queries. Should clauses only affect ranking when there's a must
+	// clause, and are required when there's only a must_not clause.
+	r.Equal([]string{"3"}, HitIDs(search(`{"query": {"bool": {
+		"must": {"term": {"keywords": "Japan"}},
+		"must_not": {"term": {"is_published": true}}
+	}}}`)))
+	r.ElementsMatch([]string{"1", "2", "4"}, HitIDs(search(`{"query": {"bool": {
+		"must": {"match": {"headline": "president"}},
+		"should": {"term": {"keywords": "Japan"}}
+	}}}`)))
+	r.Equal([]string{"1"}, HitIDs(search(`{"query": {"bool": {
+		"should": {"term": {"keywords": "Japan"}},
+		"must_not": {"match": {"headline": "markets"}}
+	}}}`)))
+	r.ElementsMatch([]string{"1", "2"}, HitIDs(search(`{"query": {"bool": {
+		"must": {"match": {"headline": "president"}},
+		"should": [{"term": {"keywords": "Japan"}}, {"match": {"headline": "budget"}}],
+		"must_not": {"term": {"id": "4"}}
+	}}}`)))
+	r.Equal([]string{"3"}, HitIDs(search(`{"query": {"bool": {
+		"should": [{"match": {"headline": "tokyo"}}, {"match": {"abstract": "tokyo"}}, {"match": {"headline": "markets"}}],
+		"minimum_should_match": 2
+	}}}`)))
+
+	_, err = e.Search(ctx, IndexName, []byte(`{"query": {"fuzzy": {"headline": "presdent"}}}`))
+	r.ErrorContains(err, "unsupported query type `fuzzy`")
+
+	// Re-indexing a doc replaces it, along with its keywords.
+	res, err = e.BulkIndex(ctx, IndexName, []string{"3"}, []interface{}{
+		&domain.SearchArticle{ID: "3", Headline: "Markets Slump in Tokyo", Keywords: []string{"Markets"}, PubDate: "2015-02-01T00:00:00+0000"},
+	})
+	r.NoError(err)
+	r.Equal(1, res.Indexed)
+	r.NoError(e.Refresh(ctx, IndexName))
+
+	r.Empty(HitIDs(search(`{"query": {"match": {"headline": "rally"}}}`)))
+	r.Equal([]string{"3"}, HitIDs(search(`{"query": {"match": {"headline": "slump"}}}`)))
+	r.Equal([]string{"1"}, HitIDs(search(`{"query": {"term": {"keywords": "Japan"}}}`)))
+	r.Equal([]string{"3"}, HitIDs(search(`{"query": {"term": {"keywords": "Markets"}}}`)))
+
+	n, err = e.Count(ctx, IndexName)
+	r.NoError(err)
+	r.Equal(4, n)
+}
+
+// HitIDs returns the IDs of the hits of a search, in order.
+func HitIDs(sr *domain.SearchResult) []string {
+	var ids []string
+	for _, h := range sr.Hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
@@ -0,0 +1,433 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/anrid/nytimes/pkg/search/esquery"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+// Columns of the articles table that can be queried.
+var columns = map[string]bool{
+	"id":             true,
+	"headline":       true,
+	"print_headline": true,
+	"abstract":       true,
+	"lead_paragraph": true,
+	"is_published":   true,
+	"pub_date":       true,
+	"num_likes":      true,
+	"num_comments":   true,
+}
+
+// Date columns, stored as text in pubDateLayout.
+var dateColumns = map[string]bool{
+	"pub_date": true,
+}
+
+// Layout of dates as stored, and as returned by the NY Times API.
+const pubDateLayout = "2006-01-02T15:04:05-0700"
+
+// Columns in the FTS5 index.
+var ftsColumns = map[string]bool{
+	"headline":       true,
+	"abstract":       true,
+	"lead_paragraph": true,
+}
+
+// sqlQuery converts ES style queries to conditions over the articles table
+// (aliased `a`), collecting the FTS5 expressions used to rank matching
+// articles.
+type sqlQuery struct {
+	fts   string   // FTS5 table name.
+	kws   string   // Keywords table name.
+	ranks []string // FTS5 expressions of all positive match clauses.
+}
+
+// Search runs an ES style JSON query, see esquery.Parse. Match and
+// match_phrase queries are supported on headline, abstract and
+// lead_paragraph (using FTS5), term and terms queries on any column,
+// keywords and multimedia fields. Hits are ranked by FTS5's bm25. Terms
+// aggregations are supported on keywords.
+func (s *SQLite) Search(ctx context.Context, indexName string, queryJSON []byte) (*domain.SearchResult, error) {
+	req, err := esquery.Parse(queryJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	a, k, f := tables(indexName)
+	q := &sqlQuery{fts: f, kws: k}
+
+	where, whereArgs, err := q.convert(req.Query, true)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	sr := new(domain.SearchResult)
+
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s a WHERE %s`, quoteIdent(a), where), whereArgs...).Scan(&sr.Total)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not search index %s", indexName)
+	}
+
+	// Rank by how well articles match any of the match clauses, or by
+	// publication date if there are none.
+	from := fmt.Sprintf(`%s a`, quoteIdent(a))
+	args := whereArgs
+	score := "0"
+	order := "a.pub_date DESC"
+
+	if len(q.ranks) > 0 {
+		from += fmt.Sprintf(` LEFT JOIN (SELECT rowid, bm25(%[1]s) AS score FROM %[1]s WHERE %[1]s MATCH ?) r ON r.rowid = a.rowid`, quoteIdent(f))
+		args = append([]interface{}{strings.Join(q.ranks, " OR ")}, args...)
+		score = "-coalesce(r.score, 0)"
+		order = "coalesce(r.score, 0), a.rowid"
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+SELECT a.id, %s, a.headline, a.print_headline, a.abstract, a.lead_paragraph, a.is_published, a.pub_date, a.num_likes, a.num_comments, a.multimedia
+FROM %s
+WHERE %s
+ORDER BY %s
+LIMIT ? OFFSET ?`, score, from, where, order), append(args, req.Size, req.From)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not search index %s", indexName)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h domain.SearchHit
+		var headline, printHeadline, abstract, leadParagraph, pubDate, multimedia string
+		var isPublished bool
+		var numLikes, numComments int
+
+		err := rows.Scan(&h.ID, &h.Score, &headline, &printHeadline, &abstract, &leadParagraph, &isPublished, &pubDate, &numLikes, &numComments, &multimedia)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read hit from index %s", indexName)
+		}
+
+		var mm interface{}
+
+		err = json.Unmarshal([]byte(multimedia), &mm)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode multimedia of doc id %s", h.ID)
+		}
+
+		h.Source = map[string]interface{}{
+			"id":             h.ID,
+			"headline":       headline,
+			"print_headline": printHeadline,
+			"abstract":       abstract,
+			"lead_paragraph": leadParagraph,
+			"is_published":   isPublished,
+			"pub_date":       pubDate,
+			"num_likes":      numLikes,
+			"num_comments":   numComments,
+			"multimedia":     mm,
+		}
+
+		sr.Hits = append(sr.Hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not search index %s", indexName)
+	}
+
+	for name, agg := range req.Aggs {
+		if agg.Field != "keywords" {
+			return nil, errors.Errorf("unsupported aggregation %s, only terms aggregations on keywords are supported", name)
+		}
+
+		terms, err := keywordFacet(ctx, db, a, k, where, whereArgs, agg.Size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not aggregate %s", name)
+		}
+
+		if sr.Facets == nil {
+			sr.Facets = make(map[string][]domain.FacetTerm)
+		}
+		sr.Facets[name] = terms
+	}
+
+	return sr, nil
+}
+
+// keywordFacet returns the most common keywords of the articles matching
+// where.
+func keywordFacet(ctx context.Context, db *sql.DB, articles, keywords, where string, args []interface{}, size int) ([]domain.FacetTerm, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+SELECT k.keyword, count(*) AS n
+FROM %s k
+WHERE k.article_rowid IN (SELECT a.rowid FROM %s a WHERE %s)
+GROUP BY k.keyword
+ORDER BY n DESC, k.keyword
+LIMIT ?`, quoteIdent(keywords), quoteIdent(articles), where), append(append([]interface{}(nil), args...), size)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []domain.FacetTerm{}
+
+	for rows.Next() {
+		var t domain.FacetTerm
+
+		err := rows.Scan(&t.Term, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		terms = append(terms, t)
+	}
+
+	return terms, rows.Err()
+}
+
+// convert converts a query to an SQL condition along with its args. If
+// rank is true positive match clauses are used for ranking.
+func (q *sqlQuery) convert(query esquery.Query, rank bool) (string, []interface{}, error) {
+	switch query := query.(type) {
+	case *esquery.MatchAll:
+		return "1", nil, nil
+
+	case *esquery.Match:
+		var terms []string
+		for _, t := range tokenize(query.Text) {
+			terms = append(terms, ftsPhrase(t))
+		}
+
+		op := " OR "
+		if query.And {
+			op = " AND "
+		}
+
+		var expr string
+		if len(terms) > 0 {
+			expr = "(" + strings.Join(terms, op) + ")"
+		}
+
+		return q.match("match", query.Field, query.Text, expr, rank)
+
+	case *esquery.MatchPhrase:
+		return q.match("match_phrase", query.Field, query.Text, ftsPhrase(query.Text), rank)
+
+	case *esquery.Term:
+		return q.term(query.Field, query.Value)
+
+	case *esquery.Terms:
+		var conds []string
+		var args []interface{}
+
+		for _, v := range query.Values {
+			cond, condArgs, err := q.term(query.Field, v)
+			if err != nil {
+				return "", nil, err
+			}
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		if len(conds) == 0 {
+			return "0", nil, nil
+		}
+
+		return "(" + strings.Join(conds, " OR ") + ")", args, nil
+
+	case *esquery.Range:
+		return q.rangeCond(query)
+
+	case *esquery.Bool:
+		return q.boolCond(query, rank)
+
+	case *esquery.ConstantScore:
+		return q.convert(query.Filter, false)
+
+	default:
+		return "", nil, errors.Errorf("unsupported query %T", query)
+	}
+}
+
+// match returns a condition matching an FTS5 expression in a text field,
+// or nothing if expr is empty. Matching keywords is the same as a term
+// query for text.
+func (q *sqlQuery) match(typ, field, text, expr string, rank bool) (string, []interface{}, error) {
+	if field == "keywords" {
+		return q.keyword(text)
+	}
+	if !ftsColumns[field] {
+		return "", nil, errors.Errorf("%s query on field %s is not supported, only on %s", typ, field, "headline, abstract and lead_paragraph")
+	}
+	if expr == "" {
+		return "0", nil, nil
+	}
+
+	expr = field + " : " + expr
+	if rank {
+		q.ranks = append(q.ranks, expr)
+	}
+
+	return fmt.Sprintf(`a.rowid IN (SELECT rowid FROM %[1]s WHERE %[1]s MATCH ?)`, quoteIdent(q.fts)), []interface{}{expr}, nil
+}
+
+func (q *sqlQuery) boolCond(query *esquery.Bool, rank bool) (string, []interface{}, error) {
+	var must []string
+	var args []interface{}
+
+	// Each condition is added along with its args, so that args line up
+	// with placeholders.
+	for _, clause := range []struct {
+		qs     []esquery.Query
+		rank   bool
+		prefix string
+	}{
+		{query.Must, rank, ""},
+		{query.Filter, false, ""},
+		{query.MustNot, false, "NOT "},
+	} {
+		for _, sub := range clause.qs {
+			cond, condArgs, err := q.convert(sub, clause.rank)
+			if err != nil {
+				return "", nil, err
+			}
+			must = append(must, clause.prefix+cond)
+			args = append(args, condArgs...)
+		}
+	}
+
+	var should []string
+	var shouldArgs []interface{}
+
+	for _, sub := range query.Should {
+		cond, condArgs, err := q.convert(sub, rank)
+		if err != nil {
+			return "", nil, err
+		}
+		should = append(should, "("+cond+")")
+		shouldArgs = append(shouldArgs, condArgs...)
+	}
+
+	// Like ES, at least one should clause must match if there are no must
+	// or filter clauses.
+	minShould := query.MinimumShouldMatch
+	if minShould == 0 && len(query.Must) == 0 && len(query.Filter) == 0 && len(should) > 0 {
+		minShould = 1
+	}
+
+	// Otherwise should clauses only affect ranking, so they're left out
+	// of the condition along with their args.
+	if minShould > 0 {
+		must = append(must, fmt.Sprintf("(%s) >= %d", strings.Join(should, " + "), minShould))
+		args = append(args, shouldArgs...)
+	}
+
+	if len(must) == 0 {
+		return "1", nil, nil
+	}
+
+	return "(" + strings.Join(must, " AND ") + ")", args, nil
+}
+
+// term returns a condition matching a field exactly.
+func (q *sqlQuery) term(field string, value interface{}) (string, []interface{}, error) {
+	if b, ok := value.(bool); ok {
+		if b {
+			value = 1
+		} else {
+			value = 0
+		}
+	}
+
+	switch {
+	case field == "keywords":
+		return q.keyword(fmt.Sprint(value))
+
+	case strings.HasPrefix(field, "multimedia."):
+		return `EXISTS (SELECT 1 FROM json_each(a.multimedia) m WHERE json_extract(m.value, ?) = ?)`,
+			[]interface{}{"$." + strings.TrimPrefix(field, "multimedia."), value}, nil
+
+	case dateColumns[field]:
+		// Match the stored layout, see rangeCond.
+		t, err := esquery.ParseDate(value)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "invalid term on field %s", field)
+		}
+		return fmt.Sprintf(`a.%s = ?`, field), []interface{}{t.Format(pubDateLayout)}, nil
+
+	case columns[field]:
+		return fmt.Sprintf(`a.%s = ?`, field), []interface{}{value}, nil
+
+	default:
+		return "", nil, errors.Errorf("unknown field %s", field)
+	}
+}
+
+func (q *sqlQuery) keyword(value string) (string, []interface{}, error) {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM %s k WHERE k.article_rowid = a.rowid AND k.keyword = ?)`, quoteIdent(q.kws)), []interface{}{value}, nil
+}
+
+func (q *sqlQuery) rangeCond(query *esquery.Range) (string, []interface{}, error) {
+	if !columns[query.Field] {
+		return "", nil, errors.Errorf("unknown field %s", query.Field)
+	}
+
+	var conds []string
+	var args []interface{}
+
+	for _, b := range []struct {
+		op    string
+		bound interface{}
+	}{
+		{">", query.GT},
+		{">=", query.GTE},
+		{"<", query.LT},
+		{"<=", query.LTE},
+	} {
+		if b.bound == nil {
+			continue
+		}
+
+		bound := b.bound
+
+		// Dates are stored in UTC as `2006-01-02T15:04:05+0000`, so date
+		// bounds compare correctly as strings once they're in the same
+		// layout.
+		if dateColumns[query.Field] {
+			t, err := query.Date(bound)
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "invalid range on field %s", query.Field)
+			}
+			bound = t.Format(pubDateLayout)
+		}
+
+		conds = append(conds, fmt.Sprintf(`a.%s %s ?`, query.Field, b.op))
+		args = append(args, bound)
+	}
+
+	if len(conds) == 0 {
+		return "1", nil, nil
+	}
+
+	return "(" + strings.Join(conds, " AND ") + ")", args, nil
+}
+
+// tokenize splits text into words the way FTS5's default unicode61
+// tokenizer does.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ftsPhrase quotes text as an FTS5 phrase.
+func ftsPhrase(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
@@ -0,0 +1,338 @@
+// SQLite package implements a search engine backend storing articles in a
+// SQLite database, with an FTS5 full-text index over their text fields.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anrid/nytimes/pkg/domain"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // Registers the `sqlite` driver.
+)
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// SQLite stores every index in its own set of tables in a single database
+// file:
+//
+//   - INDEX holds one row per article
+//   - INDEX_keywords holds one row per article keyword
+//   - INDEX_fts is an FTS5 index over headline, abstract and lead_paragraph
+//
+// where INDEX is the index name with anything but letters, digits and
+// underscores replaced by underscores.
+type SQLite struct {
+	path          string
+	verboseOutput bool
+
+	mu      sync.Mutex // Guards db.
+	db      *sql.DB
+	writeMu sync.Mutex // SQLite allows a single writer at a time.
+
+	stats domain.BulkStats
+}
+
+func New(path string, verboseOutput bool) *SQLite {
+	return &SQLite{path: path, verboseOutput: verboseOutput}
+}
+
+// conn returns the database, opening it if needed.
+func (s *SQLite) conn() (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return s.db, nil
+	}
+
+	db, err := sql.Open("sqlite", s.path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open database %s", s.path)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "could not open database %s", s.path)
+	}
+
+	s.db = db
+
+	return db, nil
+}
+
+// tables returns the names of the tables the given index is stored in.
+func tables(indexName string) (articles, keywords, fts string) {
+	t := nonIdent.ReplaceAllString(indexName, "_")
+	return t, t + "_keywords", t + "_fts"
+}
+
+// quoteIdent quotes an SQL identifier, such as a table name. Unlike Go
+// quoting, SQL escapes `"` by doubling it.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// CreateIndex creates the tables for an index, dropping any existing ones
+// first. The schema is fixed (see domain.SearchArticle), so the mappings
+// file is only checked for existence.
+func (s *SQLite) CreateIndex(ctx context.Context, mappingsJSONFile, indexName string) error {
+	err := s.DeleteIndex(ctx, indexName)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.conn()
+	if err != nil {
+		return err
+	}
+
+	a, k, f := tables(indexName)
+
+	schema := fmt.Sprintf(`
+CREATE TABLE %[1]s (
+	rowid          INTEGER PRIMARY KEY,
+	id             TEXT NOT NULL UNIQUE,
+	headline       TEXT NOT NULL,
+	print_headline TEXT NOT NULL,
+	abstract       TEXT NOT NULL,
+	lead_paragraph TEXT NOT NULL,
+	is_published   INTEGER NOT NULL,
+	pub_date       TEXT NOT NULL,
+	num_likes      INTEGER NOT NULL,
+	num_comments   INTEGER NOT NULL,
+	multimedia     TEXT NOT NULL
+);
+
+CREATE INDEX %[4]s ON %[1]s (pub_date);
+
+CREATE TABLE %[2]s (
+	article_rowid INTEGER NOT NULL,
+	keyword       TEXT NOT NULL
+);
+
+CREATE INDEX %[5]s ON %[2]s (keyword);
+CREATE INDEX %[6]s ON %[2]s (article_rowid);
+
+CREATE VIRTUAL TABLE %[3]s USING fts5(
+	headline, abstract, lead_paragraph,
+	content=%[1]s, content_rowid='rowid'
+);
+
+CREATE TRIGGER %[7]s AFTER INSERT ON %[1]s BEGIN
+	INSERT INTO %[3]s (rowid, headline, abstract, lead_paragraph)
+	VALUES (new.rowid, new.headline, new.abstract, new.lead_paragraph);
+END;
+
+CREATE TRIGGER %[8]s AFTER DELETE ON %[1]s BEGIN
+	INSERT INTO %[3]s (%[3]s, rowid, headline, abstract, lead_paragraph)
+	VALUES ('delete', old.rowid, old.headline, old.abstract, old.lead_paragraph);
+	DELETE FROM %[2]s WHERE article_rowid = old.rowid;
+END;
+`, quoteIdent(a), quoteIdent(k), quoteIdent(f), quoteIdent(a+"_pub_date"), quoteIdent(k+"_keyword"), quoteIdent(k+"_article"), quoteIdent(a+"_ai"), quoteIdent(a+"_ad"))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err = db.ExecContext(ctx, schema)
+	if err != nil {
+		return errors.Wrapf(err, "could not create index %s", indexName)
+	}
+
+	fmt.Printf("Created new index `%s` in %s\n", indexName, s.path)
+
+	return nil
+}
+
+func (s *SQLite) DeleteIndex(ctx context.Context, indexName string) error {
+	db, err := s.conn()
+	if err != nil {
+		return err
+	}
+
+	a, k, f := tables(indexName)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// Dropping the articles table drops its indexes and triggers too.
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+DROP TABLE IF EXISTS %s;
+DROP TABLE IF EXISTS %s;
+DROP TABLE IF EXISTS %s;
+`, quoteIdent(f), quoteIdent(k), quoteIdent(a)))
+	if err != nil {
+		return errors.Wrapf(err, "could not delete index %s", indexName)
+	}
+
+	fmt.Printf("Deleted existing index `%s`\n", indexName)
+
+	return nil
+}
+
+// BulkIndex inserts or replaces docs in a single transaction. Docs that
+// aren't articles are listed in the result and not indexed. Concurrent
+// bulks wait for each other, as SQLite only allows one writer.
+func (s *SQLite) BulkIndex(ctx context.Context, indexName string, docIDs []string, docs []interface{}) (*domain.BulkResult, error) {
+	err := domain.CheckBulk(docIDs, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	a, k, _ := tables(indexName)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	timer := time.Now()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	del, err := tx.PrepareContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, quoteIdent(a)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare statement for index %s", indexName)
+	}
+	defer del.Close()
+
+	ins, err := tx.PrepareContext(ctx, fmt.Sprintf(`
+INSERT INTO %s (id, headline, print_headline, abstract, lead_paragraph, is_published, pub_date, num_likes, num_comments, multimedia)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, quoteIdent(a)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare statement for index %s", indexName)
+	}
+	defer ins.Close()
+
+	insKeyword, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %s (article_rowid, keyword) VALUES (?, ?)`, quoteIdent(k)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare statement for index %s", indexName)
+	}
+	defer insKeyword.Close()
+
+	result := new(domain.BulkResult)
+
+	for i, id := range docIDs {
+		sa, err := toSearchArticle(docs[i])
+		if err != nil {
+			result.Failures = append(result.Failures, domain.InvalidDoc(i, id, err))
+			continue
+		}
+
+		multimedia, err := json.Marshal(sa.Multimedia)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not marshal multimedia of doc id %s", id)
+		}
+
+		// Replace any existing doc with the same ID, like ES.
+		_, err = del.ExecContext(ctx, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not delete doc id %s", id)
+		}
+
+		res, err := ins.ExecContext(ctx, id, sa.Headline, sa.PrintHeadline, sa.Abstract, sa.LeadParagraph,
+			sa.IsPublished, sa.PubDate, sa.NumLikes, sa.NumComments, string(multimedia))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not insert doc id %s", id)
+		}
+
+		rowid, err := res.LastInsertId()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not insert doc id %s", id)
+		}
+
+		for _, kw := range sa.Keywords {
+			_, err := insKeyword.ExecContext(ctx, rowid, kw)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not insert keywords of doc id %s", id)
+			}
+		}
+
+		result.Indexed++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not commit bulk")
+	}
+
+	s.stats.Add(result.Indexed, time.Since(timer))
+
+	if s.verboseOutput {
+		fmt.Printf("Bulk indexed %d docs (%d failed)\n", result.Indexed, len(result.Failures))
+	}
+
+	return result, nil
+}
+
+// toSearchArticle converts a doc to an article, see domain.DecodeDoc.
+func toSearchArticle(doc interface{}) (*domain.SearchArticle, error) {
+	if sa, ok := doc.(*domain.SearchArticle); ok {
+		return sa, nil
+	}
+
+	sa := new(domain.SearchArticle)
+
+	err := domain.DecodeDoc(doc, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	return sa, nil
+}
+
+func (s *SQLite) Refresh(ctx context.Context, indexName string) error {
+	_, err := s.Count(ctx, indexName)
+	return err
+}
+
+func (s *SQLite) Count(ctx context.Context, indexName string) (int, error) {
+	db, err := s.conn()
+	if err != nil {
+		return 0, err
+	}
+
+	a, _, _ := tables(indexName)
+
+	var n int
+
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s`, quoteIdent(a))).Scan(&n)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not count docs in index %s", indexName)
+	}
+
+	return n, nil
+}
+
+func (s *SQLite) PrintBulkIndexingRate() {
+	s.stats.Print()
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+
+	err := s.db.Close()
+	s.db = nil
+
+	return errors.Wrapf(err, "could not close database %s", s.path)
+}
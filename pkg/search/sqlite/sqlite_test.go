@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/anrid/nytimes/pkg/search/searchtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := New(filepath.Join(t.TempDir(), "test.db"), false)
+	defer s.Close()
+
+	searchtest.Run(t, s)
+
+	search := func(q string) []string {
+		sr, err := s.Search(ctx, searchtest.IndexName, []byte(q))
+		r.NoError(err)
+		return searchtest.HitIDs(sr)
+	}
+
+	// Terms on pub_date are compared in the stored layout, like ranges.
+	r.Equal([]string{"2"}, search(`{"query": {"term": {"pub_date": "2015-01-10"}}}`))
+	r.Equal([]string{"2"}, search(`{"query": {"term": {"pub_date": 1420848000000}}}`))
+	r.ElementsMatch([]string{"1", "2"}, search(`{"query": {"terms": {"pub_date": ["2014-04-23T09:00:00+09:00", "2015-01-10T00:00:00Z"]}}}`))
+
+	// Re-indexing a doc deletes its old keyword rows.
+	db, err := s.conn()
+	r.NoError(err)
+
+	_, k, _ := tables(searchtest.IndexName)
+
+	var keywords []string
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT keyword FROM %s WHERE article_rowid = (SELECT rowid FROM %s WHERE id = '3')`, quoteIdent(k), quoteIdent(searchtest.IndexName)))
+	r.NoError(err)
+	for rows.Next() {
+		var kw string
+		r.NoError(rows.Scan(&kw))
+		keywords = append(keywords, kw)
+	}
+	r.NoError(rows.Err())
+	r.Equal([]string{"Markets"}, keywords)
+
+	// The database can be reopened.
+	r.NoError(s.Close())
+
+	n, err := s.Count(ctx, searchtest.IndexName)
+	r.NoError(err)
+	r.Equal(4, n)
+
+	r.NoError(s.DeleteIndex(ctx, searchtest.IndexName))
+	_, err = s.Count(ctx, searchtest.IndexName)
+	r.Error(err)
+}
+
+func TestQuoteIdent(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(`"articles"`, quoteIdent("articles"))
+	r.Equal(`"a""b\c"`, quoteIdent(`a"b\c`))
+}
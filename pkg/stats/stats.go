@@ -10,18 +10,29 @@ import (
 	"github.com/anrid/nytimes/pkg/domain"
 )
 
+var tokenizer = regexp.MustCompile(`[^[[:alnum:]]+`)
+
+// Tokenize splits text into lowercase words.
+func Tokenize(text string) []string {
+	var words []string
+	for _, w := range tokenizer.Split(strings.ToLower(text), -1) {
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
 func New() *Stats {
 	return &Stats{
-		timer:     time.Now(),
-		tokenizer: *regexp.MustCompile(`[^[[:alnum:]]+`),
-		Words:     make(map[string]uint64),
-		Keywords:  make(map[string]uint64),
+		timer:    time.Now(),
+		Words:    make(map[string]uint64),
+		Keywords: make(map[string]uint64),
 	}
 }
 
 type Stats struct {
 	timer             time.Time
-	tokenizer         regexp.Regexp
 	TotalArticleCount uint64
 	TotalWordCount    uint64
 	HeadlineWordCount uint64
@@ -32,32 +43,26 @@ type Stats struct {
 }
 
 func (s *Stats) Read(a *domain.NYTimesArticle) {
-	hm := s.tokenizer.Split(strings.ToLower(a.Headline.Main), -1)
-	hp := s.tokenizer.Split(strings.ToLower(a.Headline.PrintHeadline), -1)
-	lp := s.tokenizer.Split(strings.ToLower(a.LeadParagraph), -1)
+	hm := Tokenize(a.Headline.Main)
+	hp := Tokenize(a.Headline.PrintHeadline)
+	lp := Tokenize(a.LeadParagraph)
 
 	s.TotalArticleCount++
 
 	for _, w := range hm {
-		if w != "" {
-			s.Words[w]++
-			s.HeadlineWordCount++
-			s.TotalWordCount++
-		}
+		s.Words[w]++
+		s.HeadlineWordCount++
+		s.TotalWordCount++
 	}
 	for _, w := range hp {
-		if w != "" {
-			s.Words[w]++
-			s.HeadlineWordCount++
-			s.TotalWordCount++
-		}
+		s.Words[w]++
+		s.HeadlineWordCount++
+		s.TotalWordCount++
 	}
 	for _, w := range lp {
-		if w != "" {
-			s.Words[w]++
-			s.LeadWordCount++
-			s.TotalWordCount++
-		}
+		s.Words[w]++
+		s.LeadWordCount++
+		s.TotalWordCount++
 	}
 	for _, kw := range a.Keywords {
 		s.Keywords[kw.Value]++
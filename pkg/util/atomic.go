@@ -0,0 +1,94 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFileAtomic writes a file by calling write with a temp file in the
+// same dir, fsyncing it, calling validate (if set) with the temp file's
+// path and finally renaming it to file. If anything fails the temp file is
+// removed and any existing file is left untouched.
+func WriteFileAtomic(file string, write func(w io.Writer) error, validate func(tmpFile string) error) (err error) {
+	dir := filepath.Dir(file)
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return errors.Wrapf(err, "could not create dir %s", dir)
+	}
+
+	// Temp files are named `.<name>.tmp-<random>` so that they're hidden
+	// and don't match the suffix of any real file.
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return errors.Wrapf(err, "could not create temp file for %s", file)
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	err = write(tmp)
+	if err != nil {
+		return errors.Wrapf(err, "could not write temp file for %s", file)
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "could not sync temp file for %s", file)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not close temp file for %s", file)
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	if validate != nil {
+		err = validate(tmp.Name())
+		if err != nil {
+			return errors.Wrapf(err, "validation of temp file for %s failed", file)
+		}
+	}
+
+	err = os.Rename(tmp.Name(), file)
+	if err != nil {
+		return errors.Wrapf(err, "could not rename temp file to %s", file)
+	}
+
+	// Sync the dir to make sure the rename itself is durable.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// RemoveTempFiles removes temp files left behind in dir by writes that
+// were interrupted by a crash, returning the removed files.
+func RemoveTempFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		err = os.Remove(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not remove temp file %s", f)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "articles-2023-1.json.gz")
+
+	r.NoError(WriteFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write([]byte(`{"response":{"docs":[]}}`))
+		return err
+	}, nil))
+
+	before, err := os.ReadFile(file)
+	r.NoError(err)
+
+	// A failed validation leaves the existing file untouched and cleans up
+	// the temp file.
+	err = WriteFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write([]byte("garbage"))
+		return err
+	}, func(tmpFile string) error {
+		return errors.New("nope")
+	})
+	r.Error(err)
+
+	after, err := os.ReadFile(file)
+	r.NoError(err)
+	r.Equal(before, after)
+
+	des, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(des, 1)
+
+	// Temp files left behind by a crash are removed.
+	r.NoError(os.WriteFile(filepath.Join(dir, ".articles-2023-2.json.gz.tmp-123"), []byte("partial"), 0o644))
+
+	removed, err := RemoveTempFiles(dir)
+	r.NoError(err)
+	r.Len(removed, 1)
+
+	des, err = os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(des, 1)
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponential backoff delays with full jitter.
+type Backoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	MaxRetries int
+}
+
+// Delay returns the delay before retry number attempt (starting at 0).
+func (b *Backoff) Delay(attempt int) time.Duration {
+	d := b.Base << attempt
+	if d <= 0 || d > b.Max {
+		d = b.Max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Sleep sleeps for d or until ctx is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}